@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+var addr = flag.String("addr", "localhost:8082", "http address of the proxy admin API")
+
+const usage = `usage: admin [-addr host:port] command [args]
+
+commands:
+  blocks                      list clients blocked by the kill switch
+  block <client> [opens|all]  block new opens of the client, or everything and disconnect it
+  unblock <client>            lift the block of the client
+  close <client>              close open positions of the blocked client at the order servers
+`
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	log.SetFlags(0)
+
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	var (
+		method string
+		path   string
+		query  url.Values
+	)
+	switch cmd := args[0]; {
+	case cmd == "blocks" && len(args) == 1:
+		method, path = http.MethodGet, "/blocks"
+	case cmd == "block" && (len(args) == 2 || len(args) == 3):
+		method, path = http.MethodPut, "/blocks/"+args[1]
+		if len(args) == 3 {
+			query = url.Values{"mode": {args[2]}}
+		}
+	case cmd == "unblock" && len(args) == 2:
+		method, path = http.MethodDelete, "/blocks/"+args[1]
+	case cmd == "close" && len(args) == 2:
+		method, path = http.MethodPost, "/blocks/"+args[1]+"/close"
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	u := url.URL{Scheme: "http", Host: *addr, Path: path, RawQuery: query.Encode()}
+	req, err := http.NewRequest(method, u.String(), nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if res.StatusCode >= 300 {
+		log.Fatalf("%s: %s", res.Status, body)
+	}
+	fmt.Print(string(body))
+}
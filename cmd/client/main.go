@@ -1,31 +1,48 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/gorilla/websocket"
 	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/loadgen"
 )
 
 var (
-	addr                  = flag.String("addr", "localhost:8080", "http service address")
-	instrument            = flag.String("inst", "EURUSD", "instrument")
-	interval              = flag.Duration("inter", 2*time.Second, "interval of sending request")
-	seededRand *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
-	clientID              = seededRand.Uint32()
+	addr                   = flag.String("addr", "localhost:8080", "http service address")
+	instrument             = flag.String("inst", "EURUSD", "instrument")
+	interval               = flag.Duration("inter", 2*time.Second, "interval of sending request")
+	load                   = flag.Bool("load", false, "run load test with many clients")
+	clients                = flag.Int("clients", 100, "number of concurrent clients in load test")
+	instruments            = flag.String("insts", "EURUSD,USDRUB,XLMEUR,USDEUR", "comma separated instruments of load test")
+	duration               = flag.Duration("duration", 30*time.Second, "duration of load test")
+	maxOpen                = flag.Int("maxOpen", 4, "orders a load test client keeps opened per instrument")
+	maxVolume              = flag.Float64("maxVolume", 100, "maximum volume of an order")
+	wait                   = flag.Duration("wait", 5*time.Second, "time to wait for outstanding responses after load test")
+	reportPath             = flag.String("report", "", "file to write load test summary to, stdout if empty")
+	seededRand  *rand.Rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	clientID               = seededRand.Uint32()
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
 
+	if *load {
+		runLoadTest()
+		return
+	}
+
 	interrupt := make(chan os.Signal, 1)
 	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
 
@@ -82,7 +99,7 @@ func startFakingOrders(c *websocket.Conn, done chan struct{}, interrupt chan os.
 				Instrument: *instrument,
 			}
 
-			err := c.WriteMessage(websocket.TextMessage, proxy.EncodeOrderRequest(req))
+			err := c.WriteMessage(websocket.BinaryMessage, proxy.EncodeOrderRequest(req))
 			if err != nil {
 				log.Printf("send err: %v", err)
 				continue
@@ -109,6 +126,38 @@ func startFakingOrders(c *websocket.Conn, done chan struct{}, interrupt chan os.
 	}
 }
 
+func runLoadTest() {
+	log.Printf("load testing %s with %d clients for %v", *addr, *clients, *duration)
+	report, err := loadgen.Run(loadgen.Config{
+		Addr:          *addr,
+		Clients:       *clients,
+		Instruments:   strings.Split(*instruments, ","),
+		Duration:      *duration,
+		Interval:      *interval,
+		MaxOpen:       *maxOpen,
+		MaxVolume:     *maxVolume,
+		Wait:          *wait,
+		FirstClientID: clientID,
+		Seed:          time.Now().UnixNano(),
+	})
+	if err != nil {
+		log.Fatal("load test:", err)
+	}
+
+	summary, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Fatal("encode summary:", err)
+	}
+	if *reportPath == "" {
+		os.Stdout.Write(append(summary, '\n'))
+		return
+	}
+	if err := ioutil.WriteFile(*reportPath, summary, 0644); err != nil {
+		log.Fatal("write summary:", err)
+	}
+	log.Printf("summary written to %s", *reportPath)
+}
+
 func getRandomReqType() uint8 {
 	return uint8(rand.Intn(2-1+1) + 1)
 }
@@ -1,33 +1,149 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
 	"log"
+	"os"
+	"strings"
 
+	proxy "test.task/backend/proxy"
 	"test.task/backend/proxy/internal/action"
 	"test.task/backend/proxy/internal/adapter"
+	"test.task/backend/proxy/internal/admin"
+	"test.task/backend/proxy/internal/capture"
+	"test.task/backend/proxy/internal/config"
 	"test.task/backend/proxy/internal/handlers"
 	"test.task/backend/proxy/internal/http"
 	"test.task/backend/proxy/internal/service"
-)
-
-var (
-	addr           = flag.String("addr", "localhost:8080", "http proxy address")
-	backendAddr    = flag.String("backendAddr", "localhost:8081", "http service address")
-	ordersLimit    = flag.Uint("N", 4, "opened orders per client per instrument")
-	volumeSumLimit = flag.Float64("S", 4400, "sum of volumes per client per instrument")
+	"test.task/backend/proxy/internal/upstream"
 )
 
 func main() {
-	flag.Parse()
 	log.SetFlags(0)
+	cfg, printConfig, err := config.Load("proxy", os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	if printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			log.Fatal("print config: ", err)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatal(err)
+	}
 
 	orderAdapter := adapter.NewOrderAdapter()
-	ordersService := service.NewOrdersService(*ordersLimit, *volumeSumLimit)
-	clientsService := service.NewClientsService()
-	proxyHandler := handlers.NewProxyHandler(*backendAddr, orderAdapter, ordersService, clientsService)
+	rules, err := cfg.Limits.Rules()
+	if err != nil {
+		log.Fatal("rules:", err)
+	}
+	shadowRules, err := cfg.Shadow.Rules()
+	if err != nil {
+		log.Fatal("shadow rules:", err)
+	}
+	var blocks map[uint32]service.BlockMode
+	if cfg.Admin.Blocks != "" {
+		if blocks, err = service.LoadBlocks(cfg.Admin.Blocks); err != nil {
+			log.Fatal("load blocks:", err)
+		}
+	}
+	clientsService := service.NewClientsService(service.WithBlocks(cfg.Admin.Blocks, blocks))
+	ordersService := service.NewOrdersService(cfg.Limits.N, cfg.Limits.S,
+		service.WithRules(append([]service.Rule{clientsService}, rules...)...),
+		service.WithShadowRules(shadowRules...),
+	)
+	var schedules map[string]service.Schedule
+	if cfg.Trading.Schedules != "" {
+		if schedules, err = service.LoadSchedules(cfg.Trading.Schedules); err != nil {
+			log.Fatal("load schedules:", err)
+		}
+	}
+	proxy.InternInstruments(knownInstruments(cfg, schedules)...)
+	tradingService, err := service.NewTradingService(schedules, cfg.Trading.CloseWhenHalted)
+	if err != nil {
+		log.Fatal("trading service:", err)
+	}
+	backends := upstream.NewPool(cfg.Upstream.Backends, cfg.Upstream.Sticky)
+	routes, routePools, err := routes(cfg.Upstream)
+	if err != nil {
+		log.Fatal("routes:", err)
+	}
+	headers, err := config.ParseHeaders(cfg.Upgrader.RequiredHeaders)
+	if err != nil {
+		log.Fatal("required headers:", err)
+	}
+	upgraderCfg := handlers.UpgraderConfig{
+		AllowedOrigins:    cfg.Upgrader.AllowedOrigins,
+		RequiredHeaders:   headers,
+		MaxMessageSize:    cfg.Upgrader.MaxMessageSize,
+		ReadBufferSize:    cfg.Upgrader.ReadBufferSize,
+		WriteBufferSize:   cfg.Upgrader.WriteBufferSize,
+		EnableCompression: cfg.Upgrader.Compression,
+		CompressionLevel:  cfg.Upgrader.CompressionLevel,
+	}
+	handlerOpts := []handlers.Option{
+		handlers.WithUpgrader(upgraderCfg),
+		handlers.WithTradingService(tradingService),
+		handlers.WithBackends(backends),
+		handlers.WithRoutes(routes...),
+		handlers.WithKillSwitch(clientsService),
+	}
+	if cfg.Limits.BatchAtomic {
+		handlerOpts = append(handlerOpts, handlers.WithAtomicBatches())
+	}
+	if cfg.Upstream.Batch {
+		handlerOpts = append(handlerOpts, handlers.WithUpstreamBatching())
+	}
+	if cfg.Upstream.Timeout > 0 {
+		handlerOpts = append(handlerOpts, handlers.WithUpstreamTimeout(cfg.Upstream.Timeout))
+	}
+	if cfg.Upstream.Inflight > 0 {
+		handlerOpts = append(handlerOpts, handlers.WithInflightWindow(cfg.Upstream.Inflight, cfg.Upstream.InflightQueue))
+	}
+	adminOpts := []admin.Option{admin.WithHalts(tradingService), admin.WithBlocks(clientsService)}
+	if cfg.Breaker.Rate > 0 {
+		breakers := upstream.NewBreakers(upstream.BreakerConfig{
+			Window:      cfg.Breaker.Window,
+			MinRequests: cfg.Breaker.MinRequests,
+			FailureRate: cfg.Breaker.Rate,
+			OpenFor:     cfg.Breaker.OpenFor,
+			Probes:      cfg.Breaker.Probes,
+		})
+		handlerOpts = append(handlerOpts, handlers.WithBreakers(breakers))
+		adminOpts = append(adminOpts, admin.WithBreakers(breakers))
+	}
+	if cfg.Capture != "" {
+		recorder, err := capture.Create(cfg.Capture)
+		if err != nil {
+			log.Fatal("create capture file:", err)
+		}
+		log.Printf("capturing frames to %s", cfg.Capture)
+		handlerOpts = append(handlerOpts, handlers.WithRecorder(recorder))
+	}
+	proxyHandler := handlers.NewProxyHandler("", orderAdapter, ordersService, clientsService, handlerOpts...)
+	adminOpts = append(adminOpts, admin.WithBulkClose(proxyHandler))
 
-	server := http.NewServer(*addr, proxyHandler)
+	serverOpts := []http.Option{http.WithAdmission(http.AdmissionConfig{
+		MaxSessions:      cfg.Listener.MaxSessions,
+		MaxSessionsPerIP: cfg.Listener.MaxSessionsPerIP,
+		UpgradeRate:      cfg.Listener.UpgradeRate,
+		UpgradeBurst:     cfg.Listener.UpgradeBurst,
+		CloseFrame:       cfg.Listener.RejectWithClose,
+		Upgrader:         proxyHandler.Upgrader(),
+	})}
+	if cfg.Listener.TLSCert != "" {
+		serverOpts = append(serverOpts, http.WithTLS(cfg.Listener.TLSCert, cfg.Listener.TLSKey))
+	}
+	server := http.NewServer(cfg.Listener.Addr, proxyHandler, serverOpts...)
 
 	errorChannel := make(chan error)
 	doneChannel := make(chan struct{})
@@ -35,5 +151,53 @@ func main() {
 	go func() {
 		errorChannel <- server.Open()
 	}()
-	action.GracefulShutdown(errorChannel, server, doneChannel)
+	go backends.Run(cfg.Upstream.HealthInterval, doneChannel)
+	for _, pool := range routePools {
+		go pool.Run(cfg.Upstream.HealthInterval, doneChannel)
+	}
+	servers := []http.Server{server}
+	if cfg.Admin.Addr != "" {
+		adminServer := http.NewServer(cfg.Admin.Addr, admin.NewHandler(adminOpts...))
+		servers = append(servers, adminServer)
+		go func() {
+			errorChannel <- fmt.Errorf("admin server: %w", adminServer.Open())
+		}()
+	}
+	action.GracefulShutdown(errorChannel, doneChannel, servers...)
+}
+
+// knownInstruments returns the instruments named in the configuration
+func knownInstruments(cfg config.Config, schedules map[string]service.Schedule) []string {
+	res := append([]string(nil), cfg.Trading.Instruments...)
+	res = append(res, cfg.Limits.Blacklist...)
+	for instrument := range schedules {
+		if instrument != service.AnyInstrument {
+			res = append(res, instrument)
+		}
+	}
+	routes, _ := config.ParseRoutes(cfg.Upstream.Routes)
+	for _, r := range routes {
+		if !strings.HasSuffix(r.Pattern, "*") {
+			res = append(res, r.Pattern)
+		}
+	}
+	return res
+}
+
+// routes builds the instrument routes with the pools of their backends
+func routes(cfg config.Upstream) ([]upstream.Route, []*upstream.Pool, error) {
+	parsed, err := config.ParseRoutes(cfg.Routes)
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		res   []upstream.Route
+		pools []*upstream.Pool
+	)
+	for _, r := range parsed {
+		pool := upstream.NewPool(r.Backends, cfg.Sticky)
+		res = append(res, upstream.Route{Pattern: r.Pattern, Backends: pool})
+		pools = append(pools, pool)
+	}
+	return res, pools, nil
 }
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/adapter"
+	"test.task/backend/proxy/internal/capture"
+	"test.task/backend/proxy/internal/config"
+	"test.task/backend/proxy/internal/model"
+	"test.task/backend/proxy/internal/service"
+)
+
+var (
+	capturePath = flag.String("capture", "capture.jsonl", "capture file to replay")
+	addr        = flag.String("addr", "localhost:8080", "http proxy address")
+	direct      = flag.Bool("direct", false, "replay against orders service in-process instead of a running proxy")
+	configPath  = flag.String("config", "", "configuration file of the proxy the capture ran under, with PROXY_* environment variables, direct mode only")
+	speed       = flag.Float64("speed", 1, "replay speed multiplier, 0 replays without delays")
+	wait        = flag.Duration("wait", 2*time.Second, "time to wait for outstanding responses")
+)
+
+// requestKey identifies requests with an ID within a captured session,
+// a client may reuse IDs so a key holds result codes in the order of answers
+type requestKey struct {
+	session uint64
+	id      uint32
+}
+
+type results struct {
+	sync.Mutex
+	codes map[requestKey][]uint16
+}
+
+func (r *results) add(k requestKey, code uint16) {
+	r.Lock()
+	defer r.Unlock()
+	r.codes[k] = append(r.codes[k], code)
+}
+
+func main() {
+	flag.Parse()
+	log.SetFlags(0)
+
+	frames, err := capture.Load(*capturePath)
+	if err != nil {
+		log.Fatal("load capture:", err)
+	}
+	log.Printf("loaded %d frames from %s", len(frames), *capturePath)
+
+	subprotocols := sessionSubprotocols(frames)
+	expected := expectedCodes(frames, subprotocols)
+	got := &results{codes: make(map[requestKey][]uint16)}
+	if *direct {
+		replayDirect(frames, subprotocols, got)
+	} else {
+		replayProxy(frames, subprotocols, expected, got)
+	}
+
+	if !report(expected, got.codes) {
+		os.Exit(1)
+	}
+}
+
+// expectedCodes collects result codes the client received during the capture
+// in the order of answers. In direct mode the order server isn't involved,
+// so every request the proxy forwarded upstream is expected to be accepted
+// by the orders service. Server responses beyond the requests sent with
+// the ID are late ones the proxy dropped.
+func expectedCodes(frames []capture.Frame, subprotocols map[uint64]string) map[requestKey][]uint16 {
+	codes := make(map[requestKey][]uint16)
+	sent := make(map[requestKey]int)
+	for _, frame := range frames {
+		if proxy.IsHello(frame.Data) {
+			continue
+		}
+		var res []proxy.OrderResponse
+		var err error
+		switch frame.Direction {
+		case capture.DirectionClient:
+			reqs, err := decodeRequests(subprotocols[frame.Session], frame.Data)
+			if err != nil {
+				log.Printf("session %d decode request: %v", frame.Session, err)
+				continue
+			}
+			for _, req := range reqs {
+				sent[requestKey{session: frame.Session, id: req.ID}]++
+			}
+			continue
+		case capture.DirectionUpstream:
+			res, err = decodeUpstreamResponses(frame.Data)
+		case capture.DirectionProxy:
+			res, err = decodeResponses(subprotocols[frame.Session], frame.Data)
+		default:
+			continue
+		}
+		if err != nil {
+			log.Printf("session %d decode response: %v", frame.Session, err)
+			continue
+		}
+		for _, r := range res {
+			k := requestKey{session: frame.Session, id: r.ID}
+			if len(codes[k]) >= sent[k] {
+				continue
+			}
+			code := r.Code
+			if *direct && frame.Direction == capture.DirectionUpstream {
+				code = uint16(model.ResultCodeSuccess)
+			}
+			codes[k] = append(codes[k], code)
+		}
+	}
+	return codes
+}
+
+// span is the time a session was connected in the capture
+type span struct {
+	first, last time.Duration
+}
+
+// replayProxy opens a connection per captured session and sends client frames
+// keeping the original timing between them. A session is connected at the
+// offset of its first frame and closed at its last, once sessions which were
+// over by then are closed, so reconnects of a client don't overlap.
+func replayProxy(frames []capture.Frame, subprotocols map[uint64]string, expected map[requestKey][]uint16, got *results) {
+	sessions := make(map[uint64][]capture.Frame)
+	spans := make(map[uint64]span)
+	for _, frame := range frames {
+		sp, ok := spans[frame.Session]
+		if !ok {
+			sp.first = frame.Time
+		}
+		sp.last = frame.Time
+		spans[frame.Session] = sp
+		if frame.Direction == capture.DirectionClient {
+			sessions[frame.Session] = append(sessions[frame.Session], frame)
+		}
+	}
+	answers := make(map[uint64]int)
+	for k, codes := range expected {
+		answers[k.session] += len(codes)
+	}
+	closed := make(map[uint64]chan struct{}, len(sessions))
+	for id := range sessions {
+		closed[id] = make(chan struct{})
+	}
+
+	u := url.URL{Scheme: "ws", Host: *addr, Path: "/"}
+	start := time.Now()
+	var wg sync.WaitGroup
+	for id, sessionFrames := range sessions {
+		var before []chan struct{}
+		for other := range sessions {
+			if spans[other].last < spans[id].first {
+				before = append(before, closed[other])
+			}
+		}
+		wg.Add(1)
+		go func(id uint64, sessionFrames []capture.Frame, before []chan struct{}) {
+			defer wg.Done()
+			defer close(closed[id])
+			for _, ch := range before {
+				<-ch
+			}
+			sleepUntil(start, spans[id].first)
+			replaySession(u.String(), id, subprotocols[id], sessionFrames, answers[id], start, spans[id].last, got)
+		}(id, sessionFrames, before)
+	}
+	wg.Wait()
+}
+
+// replaySession sends the frames of the session and waits for the answers
+// it got in the capture
+func replaySession(addr string, id uint64, subprotocol string, frames []capture.Frame, answers int,
+	start time.Time, last time.Duration, got *results) {
+	dialer := *websocket.DefaultDialer
+	if subprotocol != "" {
+		dialer.Subprotocols = []string{subprotocol}
+	}
+	c, _, err := dialer.Dial(addr, nil)
+	if err != nil {
+		log.Printf("session %d dial: %v", id, err)
+		return
+	}
+	defer c.Close()
+
+	done := make(chan struct{})
+	answered := make(chan struct{})
+	go func() {
+		defer close(done)
+		remaining := answers
+		for {
+			_, message, err := c.ReadMessage()
+			if err != nil {
+				return
+			}
+			if proxy.IsHello(message) {
+				continue
+			}
+			res, err := decodeResponses(subprotocol, message)
+			if err != nil {
+				log.Printf("session %d decode response: %v", id, err)
+				continue
+			}
+			for _, r := range res {
+				got.add(requestKey{session: id, id: r.ID}, r.Code)
+			}
+			if remaining > 0 {
+				if remaining -= len(res); remaining <= 0 {
+					close(answered)
+				}
+			}
+		}
+	}()
+
+	for _, frame := range frames {
+		sleepUntil(start, frame.Time)
+		if err := c.WriteMessage(frame.MessageType, frame.Data); err != nil {
+			log.Printf("session %d send: %v", id, err)
+			break
+		}
+	}
+
+	if answers > 0 {
+		select {
+		case <-done:
+		case <-answered:
+		case <-time.After(*wait):
+		}
+	}
+	sleepUntil(start, last)
+
+	// the proxy is done with the client once it answers the close frame
+	c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	select {
+	case <-done:
+	case <-time.After(*wait):
+	}
+}
+
+// replayDirect feeds client frames to the orders service in capture order
+func replayDirect(frames []capture.Frame, subprotocols map[uint64]string, got *results) {
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatal("config: ", err)
+	}
+	rules, err := cfg.Limits.Rules()
+	if err != nil {
+		log.Fatal("rules: ", err)
+	}
+	shadowRules, err := cfg.Shadow.Rules()
+	if err != nil {
+		log.Fatal("shadow rules: ", err)
+	}
+	orderAdapter := adapter.NewOrderAdapter()
+	ordersService := service.NewOrdersService(cfg.Limits.N, cfg.Limits.S,
+		service.WithRules(rules...),
+		service.WithShadowRules(shadowRules...),
+	)
+
+	start := time.Now()
+	for _, frame := range frames {
+		if frame.Direction != capture.DirectionClient || proxy.IsHello(frame.Data) {
+			continue
+		}
+		sleepUntil(start, frame.Time)
+
+		reqs, err := decodeRequests(subprotocols[frame.Session], frame.Data)
+		if err != nil {
+			log.Printf("session %d decode request: %v", frame.Session, err)
+			continue
+		}
+		// batches are evaluated individually, atomic batches can't be
+		// told apart in a capture
+		for _, req := range reqs {
+			k := requestKey{session: frame.Session, id: req.ID}
+			order, err := orderAdapter.TranslateOrder(req)
+			if err == nil {
+				err = ordersService.ProcessOrder(order)
+			}
+			if err != nil {
+				got.add(k, uint16(orderAdapter.GetResultCodeFromErr(err)))
+				continue
+			}
+			got.add(k, uint16(model.ResultCodeSuccess))
+		}
+	}
+}
+
+// loadConfig loads the configuration of the proxy from the file
+// and the environment the same way the proxy does
+func loadConfig() (config.Config, error) {
+	var args []string
+	if *configPath != "" {
+		args = []string{"-config", *configPath}
+	}
+	cfg, _, err := config.Load("replay", args, os.LookupEnv)
+	if err != nil {
+		return config.Config{}, err
+	}
+	return cfg, cfg.Validate()
+}
+
+// sessionSubprotocols returns encodings of captured sessions, negotiated
+// either on upgrade or later in a hello frame
+func sessionSubprotocols(frames []capture.Frame) map[uint64]string {
+	subprotocols := make(map[uint64]string)
+	for _, frame := range frames {
+		if frame.Direction == capture.DirectionOpen {
+			subprotocols[frame.Session] = string(frame.Data)
+		}
+	}
+	return subprotocols
+}
+
+func decodeRequests(subprotocol string, data []byte) ([]proxy.OrderRequest, error) {
+	switch subprotocol {
+	case proxy.SubprotocolJSON:
+		req, err := proxy.DecodeOrderRequestJSON(data)
+		return []proxy.OrderRequest{req}, err
+	case proxy.SubprotocolBatch:
+		return proxy.DecodeOrderRequestBatch(data)
+	}
+	if len(data) < proxy.OrderRequestFixedLen {
+		return nil, proxy.ErrMessageTooShort
+	}
+	return []proxy.OrderRequest{proxy.DecodeOrderRequest(data)}, nil
+}
+
+func decodeResponses(subprotocol string, data []byte) ([]proxy.OrderResponse, error) {
+	switch subprotocol {
+	case proxy.SubprotocolJSON:
+		res, err := proxy.DecodeOrderResponseJSON(data)
+		return []proxy.OrderResponse{res}, err
+	case proxy.SubprotocolBatch:
+		return proxy.DecodeOrderResponseBatch(data)
+	}
+	if len(data) < proxy.OrderResponseLen {
+		return nil, proxy.ErrMessageTooShort
+	}
+	return []proxy.OrderResponse{proxy.DecodeOrderResponse(data)}, nil
+}
+
+// decodeUpstreamResponses decodes a frame from the order server, which is
+// either a single response or a batch: a batch frame is 2+6n bytes long,
+// so it never has the length of a single response
+func decodeUpstreamResponses(data []byte) ([]proxy.OrderResponse, error) {
+	if len(data) == proxy.OrderResponseLen {
+		return []proxy.OrderResponse{proxy.DecodeOrderResponse(data)}, nil
+	}
+	return proxy.DecodeOrderResponseBatch(data)
+}
+
+func sleepUntil(start time.Time, offset time.Duration) {
+	if *speed <= 0 {
+		return
+	}
+	target := start.Add(time.Duration(float64(offset) / *speed))
+	time.Sleep(time.Until(target))
+}
+
+// report prints every divergence and returns true if there were none,
+// requests reusing an ID are matched in the order of answers
+func report(expected, got map[requestKey][]uint16) bool {
+	keys := make([]requestKey, 0, len(expected))
+	for k := range expected {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].session != keys[j].session {
+			return keys[i].session < keys[j].session
+		}
+		return keys[i].id < keys[j].id
+	})
+
+	var total, diverged, missing int
+	for _, k := range keys {
+		for i, want := range expected[k] {
+			total++
+			if i >= len(got[k]) {
+				missing++
+				log.Printf("session %d request %d: expected code %d, got no response", k.session, k.id, want)
+				continue
+			}
+			if code := got[k][i]; code != want {
+				diverged++
+				log.Printf("session %d request %d: expected code %d, got %d", k.session, k.id, want, code)
+			}
+		}
+	}
+
+	log.Printf("replayed %d requests: %d matched, %d diverged, %d without response",
+		total, total-diverged-missing, diverged, missing)
+	return diverged == 0 && missing == 0
+}
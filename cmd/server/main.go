@@ -5,47 +5,57 @@ import (
 	"log"
 	"net/http"
 
-	"github.com/gorilla/websocket"
-	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/mockserver"
 )
 
 var (
-	addr     = flag.String("addr", "localhost:8081", "http service address")
-	upgrader = websocket.Upgrader{}
+	addr            = flag.String("addr", "localhost:8081", "http service address")
+	scenarioPath    = flag.String("scenario", "", "JSON scenario file, overrides the other behavior flags")
+	rejectRate      = flag.Float64("rejectRate", 0, "probability to reject a request")
+	rejectCode      = flag.Uint("rejectCode", 3, "result code of rejected requests")
+	dropRate        = flag.Float64("dropRate", 0, "probability to never answer a request")
+	latency         = flag.Duration("latency", 0, "mean response latency")
+	latencyDist     = flag.String("latencyDist", mockserver.DistributionFixed, "latency distribution: fixed, uniform, normal or exponential")
+	latencyJitter   = flag.Duration("latencyJitter", 0, "latency standard deviation for normal and spread around the mean for uniform distribution")
+	reorderWindow   = flag.Int("reorder", 0, "send every N responses in reverse order")
+	disconnectAfter = flag.Int("disconnectAfter", 0, "close connection after N messages")
+	seed            = flag.Int64("seed", 0, "random seed, 0 seeds from the clock")
 )
 
 func main() {
 	flag.Parse()
 	log.SetFlags(0)
-	http.HandleFunc("/connect", connect)
-	log.Printf("Waiting for connections on %s/connect", *addr)
-	log.Fatal(http.ListenAndServe(*addr, nil))
-}
 
-func connect(w http.ResponseWriter, r *http.Request) {
-	c, err := upgrader.Upgrade(w, r, nil)
+	scenario, err := loadScenario()
 	if err != nil {
-		log.Print("upgrade:", err)
-		return
+		log.Fatal("scenario:", err)
 	}
-	defer c.Close()
-	for {
-		mt, message, err := c.ReadMessage()
-		if err != nil {
-			break
-		}
-		req := proxy.DecodeOrderRequest(message)
-		log.Printf("recv: %v", req)
 
-		res := proxy.OrderResponse{
-			ID:   req.ID,
-			Code: 0,
-		}
-		if err = c.WriteMessage(mt, proxy.EncodeOrderResponse(res)); err != nil {
-			log.Println("write:", err)
-			continue
-		}
+	http.Handle("/connect", mockserver.New(scenario))
+	log.Printf("Waiting for connections on %s/connect", *addr)
+	log.Fatal(http.ListenAndServe(*addr, nil))
+}
 
-		log.Printf("sent: %v", res)
+func loadScenario() (mockserver.Scenario, error) {
+	if *scenarioPath != "" {
+		return mockserver.LoadScenario(*scenarioPath)
+	}
+	scenario := mockserver.Scenario{
+		Default: mockserver.Behavior{
+			RejectRate: *rejectRate,
+			RejectCode: uint16(*rejectCode),
+			DropRate:   *dropRate,
+			Latency: mockserver.Latency{
+				Distribution: *latencyDist,
+				Min:          mockserver.Duration(*latency - *latencyJitter),
+				Max:          mockserver.Duration(*latency + *latencyJitter),
+				Mean:         mockserver.Duration(*latency),
+				StdDev:       mockserver.Duration(*latencyJitter),
+			},
+		},
+		DisconnectAfter: *disconnectAfter,
+		ReorderWindow:   *reorderWindow,
+		Seed:            *seed,
 	}
+	return scenario, scenario.Validate()
 }
@@ -2,4 +2,8 @@ module test.task/backend/proxy
 
 go 1.14
 
-require github.com/gorilla/websocket v1.4.2
+require (
+	github.com/BurntSushi/toml v1.2.1
+	github.com/gorilla/websocket v1.4.2
+	gopkg.in/yaml.v3 v3.0.1
+)
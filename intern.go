@@ -0,0 +1,63 @@
+package protocol
+
+import (
+	"sync"
+)
+
+// maxInternedInstruments bounds the interning table, so garbage
+// instruments can't grow it forever. Instruments above the limit
+// are allocated on every decode.
+const maxInternedInstruments = 1024
+
+// instruments interns instrument names. There is a small set of
+// instruments, so decoding reuses the same strings instead of
+// allocating a new one per message.
+var instruments = newInterner(maxInternedInstruments)
+
+type interner struct {
+	sync.RWMutex
+	strings map[string]string
+	max     int
+}
+
+func newInterner(max int) *interner {
+	return &interner{
+		strings: make(map[string]string),
+		max:     max,
+	}
+}
+
+// InternInstruments interns the instruments above the limit of the table,
+// so garbage instruments of clients can't take their place
+func InternInstruments(names ...string) {
+	instruments.seed(names...)
+}
+
+func (in *interner) seed(names ...string) {
+	in.Lock()
+	defer in.Unlock()
+	for _, name := range names {
+		if _, ok := in.strings[name]; !ok {
+			in.strings[name] = name
+			in.max++
+		}
+	}
+}
+
+func (in *interner) intern(b []byte) string {
+	in.RLock()
+	// map lookup by string(b) doesn't allocate
+	s, ok := in.strings[string(b)]
+	in.RUnlock()
+	if ok {
+		return s
+	}
+
+	s = string(b)
+	in.Lock()
+	if len(in.strings) < in.max {
+		in.strings[s] = s
+	}
+	in.Unlock()
+	return s
+}
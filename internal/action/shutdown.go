@@ -12,10 +12,12 @@ import (
 	"test.task/backend/proxy/internal/http"
 )
 
+// GracefulShutdown waits for the first error of the servers or a signal,
+// then closes doneChannel and shuts the servers down in order
 func GracefulShutdown(
 	errorChannel chan error,
-	httpServer http.Server,
 	doneChannel chan struct{},
+	httpServers ...http.Server,
 ) {
 	// Capture interrupts.
 	go func() {
@@ -27,7 +29,9 @@ func GracefulShutdown(
 	if err := <-errorChannel; err != nil {
 		log.Println(err)
 		close(doneChannel)
-		httpServerShutdown(httpServer)
+		for _, httpServer := range httpServers {
+			httpServerShutdown(httpServer)
+		}
 
 		log.Println("app stopped", time.Now())
 	}
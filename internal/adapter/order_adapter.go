@@ -1,6 +1,7 @@
 package adapter
 
 import (
+	"errors"
 	"fmt"
 
 	proxy "test.task/backend/proxy"
@@ -28,11 +29,47 @@ func (orderAdapter) TranslateOrder(order proxy.OrderRequest) (model.OrderRequest
 }
 
 func (orderAdapter) GetResultCodeFromErr(err error) model.ResultCode {
-	switch err {
-	case model.ErrNumberExceedes:
+	switch {
+	case errors.Is(err, model.ErrNumberExceedes):
 		return model.ResultCodeOpenOrdersExceedes
-	case model.ErrVolumeSumExceedes:
+	case errors.Is(err, model.ErrVolumeSumExceedes):
 		return model.ResultCodeVolumesExceedes
+	case errors.Is(err, model.ErrInvalidRequest):
+		return model.ResultCodeInvalidRequest
+	case errors.Is(err, model.ErrNoOrderToClose):
+		return model.ResultCodeNoOrderToClose
+	case errors.Is(err, model.ErrBatchRejected):
+		return model.ResultCodeBatchRejected
+	case errors.Is(err, model.ErrNetExposureExceedes):
+		return model.ResultCodeNetExposureExceedes
+	case errors.Is(err, model.ErrGrossExposureExceedes):
+		return model.ResultCodeGrossExposureExceedes
+	case errors.Is(err, model.ErrClientOrdersExceedes):
+		return model.ResultCodeClientOrdersExceedes
+	case errors.Is(err, model.ErrClientVolumeExceedes):
+		return model.ResultCodeClientVolumeExceedes
+	case errors.Is(err, model.ErrCurrencyExposureExceedes):
+		return model.ResultCodeCurrencyExposureExceedes
+	case errors.Is(err, model.ErrInstrumentLimitExceedes):
+		return model.ResultCodeInstrumentLimitExceedes
+	case errors.Is(err, model.ErrOrderVolumeExceedes):
+		return model.ResultCodeOrderVolumeExceedes
+	case errors.Is(err, model.ErrInstrumentBlacklisted):
+		return model.ResultCodeInstrumentBlacklisted
+	case errors.Is(err, model.ErrWindowVolumeExceedes):
+		return model.ResultCodeWindowVolumeExceedes
+	case errors.Is(err, model.ErrInstrumentHalted):
+		return model.ResultCodeInstrumentHalted
+	case errors.Is(err, model.ErrOutsideTradingHours):
+		return model.ResultCodeOutsideTradingHours
+	case errors.Is(err, model.ErrUpstreamTimeout):
+		return model.ResultCodeUpstreamTimeout
+	case errors.Is(err, model.ErrUpstreamUnavailable):
+		return model.ResultCodeUpstreamUnavailable
+	case errors.Is(err, model.ErrTooManyInflight):
+		return model.ResultCodeTooManyInflight
+	case errors.Is(err, model.ErrClientBlocked):
+		return model.ResultCodeClientBlocked
 	default:
 		return model.ResultCodeOther
 	}
@@ -2,6 +2,7 @@ package adapter
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	proxy "test.task/backend/proxy"
@@ -91,6 +92,96 @@ func TestGetResultCodeFromErrr(t *testing.T) {
 			input: model.ErrVolumeSumExceedes,
 			want:  model.ResultCodeVolumesExceedes,
 		},
+		{
+			name:  "invalid request",
+			input: fmt.Errorf("%w: invalid order kind", model.ErrInvalidRequest),
+			want:  model.ResultCodeInvalidRequest,
+		},
+		{
+			name:  "no order to close",
+			input: model.ErrNoOrderToClose,
+			want:  model.ResultCodeNoOrderToClose,
+		},
+		{
+			name:  "batch rejected",
+			input: model.ErrBatchRejected,
+			want:  model.ResultCodeBatchRejected,
+		},
+		{
+			name:  "net exposure exceedes",
+			input: model.ErrNetExposureExceedes,
+			want:  model.ResultCodeNetExposureExceedes,
+		},
+		{
+			name:  "gross exposure exceedes",
+			input: model.ErrGrossExposureExceedes,
+			want:  model.ResultCodeGrossExposureExceedes,
+		},
+		{
+			name:  "client orders exceedes",
+			input: model.ErrClientOrdersExceedes,
+			want:  model.ResultCodeClientOrdersExceedes,
+		},
+		{
+			name:  "client volume exceedes",
+			input: model.ErrClientVolumeExceedes,
+			want:  model.ResultCodeClientVolumeExceedes,
+		},
+		{
+			name:  "currency exposure exceedes",
+			input: model.ErrCurrencyExposureExceedes,
+			want:  model.ResultCodeCurrencyExposureExceedes,
+		},
+		{
+			name:  "instrument limit exceedes",
+			input: fmt.Errorf("%w: open orders", model.ErrInstrumentLimitExceedes),
+			want:  model.ResultCodeInstrumentLimitExceedes,
+		},
+		{
+			name:  "order volume exceedes",
+			input: model.ErrOrderVolumeExceedes,
+			want:  model.ResultCodeOrderVolumeExceedes,
+		},
+		{
+			name:  "instrument blacklisted",
+			input: model.ErrInstrumentBlacklisted,
+			want:  model.ResultCodeInstrumentBlacklisted,
+		},
+		{
+			name:  "window volume exceedes",
+			input: fmt.Errorf("%w: 1m0s", model.ErrWindowVolumeExceedes),
+			want:  model.ResultCodeWindowVolumeExceedes,
+		},
+		{
+			name:  "instrument halted",
+			input: model.ErrInstrumentHalted,
+			want:  model.ResultCodeInstrumentHalted,
+		},
+		{
+			name:  "outside trading hours",
+			input: model.ErrOutsideTradingHours,
+			want:  model.ResultCodeOutsideTradingHours,
+		},
+		{
+			name:  "upstream timeout",
+			input: model.ErrUpstreamTimeout,
+			want:  model.ResultCodeUpstreamTimeout,
+		},
+		{
+			name:  "upstream unavailable",
+			input: model.ErrUpstreamUnavailable,
+			want:  model.ResultCodeUpstreamUnavailable,
+		},
+		{
+			name:  "too many in flight",
+			input: model.ErrTooManyInflight,
+			want:  model.ResultCodeTooManyInflight,
+		},
+		{
+			name:  "client blocked",
+			input: model.ErrClientBlocked,
+			want:  model.ResultCodeClientBlocked,
+		},
 		{
 			name:  "random error",
 			input: errors.New("random"),
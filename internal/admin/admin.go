@@ -0,0 +1,208 @@
+// Package admin serves the operator API of the proxy
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"test.task/backend/proxy/internal/handlers"
+	"test.task/backend/proxy/internal/metrics"
+	"test.task/backend/proxy/internal/service"
+)
+
+type haltRegistry interface {
+	Halt(instrument string)
+	Resume(instrument string)
+	Halted() []string
+}
+
+type breakerRegistry interface {
+	States() map[string]string
+	Reset(route string) bool
+}
+
+type blockRegistry interface {
+	Block(clientID uint32, mode service.BlockMode) error
+	Unblock(clientID uint32) error
+	Blocks() map[uint32]service.BlockMode
+}
+
+type positionCloser interface {
+	CloseAll(clientID uint32) []handlers.ClosedOrder
+}
+
+// Option registers a part of the admin API
+type Option func(*Handler)
+
+// WithHalts serves halts of instruments:
+//
+//	GET /halts                  lists halted instruments
+//	PUT /halts/{instrument}     halts the instrument
+//	DELETE /halts/{instrument}  resumes the instrument
+func WithHalts(reg haltRegistry) Option {
+	return func(h *Handler) {
+		h.mux.HandleFunc("/halts", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, reg.Halted())
+		})
+		h.mux.HandleFunc("/halts/", func(w http.ResponseWriter, r *http.Request) {
+			instrument := strings.TrimPrefix(r.URL.Path, "/halts/")
+			if instrument == "" {
+				http.NotFound(w, r)
+				return
+			}
+			switch r.Method {
+			case http.MethodPut:
+				reg.Halt(instrument)
+			case http.MethodDelete:
+				reg.Resume(instrument)
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// WithBreakers serves circuit breakers of upstream routes:
+//
+//	GET /breakers             lists states of breakers by route
+//	DELETE /breakers/{route}  closes the breaker of the route
+func WithBreakers(reg breakerRegistry) Option {
+	return func(h *Handler) {
+		h.mux.HandleFunc("/breakers", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, reg.States())
+		})
+		h.mux.HandleFunc("/breakers/", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodDelete {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if !reg.Reset(strings.TrimPrefix(r.URL.Path, "/breakers/")) {
+				http.NotFound(w, r)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// WithBlocks serves the kill switch of clients:
+//
+//	GET /blocks                          lists blocked clients
+//	PUT /blocks/{client}?mode=opens|all  blocks opens, the default, or everything
+//	DELETE /blocks/{client}              unblocks the client
+//
+// With WithBulkClose also:
+//
+//	POST /blocks/{client}/close          closes open positions of the blocked client
+func WithBlocks(reg blockRegistry) Option {
+	return func(h *Handler) {
+		h.mux.HandleFunc("/blocks", func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			writeJSON(w, reg.Blocks())
+		})
+		h.mux.HandleFunc("/blocks/", func(w http.ResponseWriter, r *http.Request) {
+			path := strings.TrimPrefix(r.URL.Path, "/blocks/")
+			client := strings.TrimSuffix(path, "/close")
+			clientID, err := strconv.ParseUint(client, 10, 32)
+			if err != nil || client != path && h.closer == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if client != path {
+				h.closeAll(w, r, reg, uint32(clientID))
+				return
+			}
+			switch r.Method {
+			case http.MethodPut:
+				mode := service.BlockMode(r.URL.Query().Get("mode"))
+				if mode == "" {
+					mode = service.BlockOpens
+				}
+				if !mode.Valid() {
+					http.Error(w, "invalid mode", http.StatusBadRequest)
+					return
+				}
+				err = reg.Block(uint32(clientID), mode)
+			case http.MethodDelete:
+				err = reg.Unblock(uint32(clientID))
+			default:
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// WithBulkClose lets operators close open positions of blocked clients
+// at the servers, see WithBlocks
+func WithBulkClose(closer positionCloser) Option {
+	return func(h *Handler) {
+		h.closer = closer
+	}
+}
+
+// closeAll closes open positions of the client if it's blocked and
+// writes the closes with their result codes
+func (h *Handler) closeAll(w http.ResponseWriter, r *http.Request, reg blockRegistry, clientID uint32) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := reg.Blocks()[clientID]; !ok {
+		http.Error(w, "client isn't blocked", http.StatusConflict)
+		return
+	}
+	closed := h.closer.CloseAll(clientID)
+	if closed == nil {
+		closed = []handlers.ClosedOrder{}
+	}
+	writeJSON(w, closed)
+}
+
+// Handler is the admin API, metrics are always served at /debug/vars
+type Handler struct {
+	mux    *http.ServeMux
+	closer positionCloser
+}
+
+func NewHandler(opts ...Option) *Handler {
+	h := &Handler{mux: http.NewServeMux()}
+	h.mux.Handle("/debug/vars", metrics.Handler())
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	log.Printf("admin: %s %s", r.Method, r.URL.Path)
+	h.mux.ServeHTTP(w, r)
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("admin: write response:", err)
+	}
+}
@@ -0,0 +1,247 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"test.task/backend/proxy/internal/handlers"
+	"test.task/backend/proxy/internal/model"
+	"test.task/backend/proxy/internal/service"
+)
+
+type haltsMock map[string]bool
+
+func (m haltsMock) Halt(instrument string)   { m[instrument] = true }
+func (m haltsMock) Resume(instrument string) { delete(m, instrument) }
+func (m haltsMock) Halted() []string {
+	res := []string{}
+	for instrument := range m {
+		res = append(res, instrument)
+	}
+	sort.Strings(res)
+	return res
+}
+
+func TestHalts(t *testing.T) {
+	halts := haltsMock{}
+	s := httptest.NewServer(NewHandler(WithHalts(halts)))
+	defer s.Close()
+
+	for _, req := range []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodPut, "/halts/USDRUB", http.StatusNoContent},
+		{http.MethodPut, "/halts/XLMEUR", http.StatusNoContent},
+		{http.MethodDelete, "/halts/XLMEUR", http.StatusNoContent},
+		{http.MethodPost, "/halts/XLMEUR", http.StatusMethodNotAllowed},
+		{http.MethodPut, "/halts/", http.StatusNotFound},
+	} {
+		r, err := http.NewRequest(req.method, s.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != req.wantStatus {
+			t.Fatalf("%s %s: expected status %d, got: %d", req.method, req.path, req.wantStatus, res.StatusCode)
+		}
+	}
+
+	res, err := http.Get(s.URL + "/halts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got []string
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0] != "USDRUB" {
+		t.Fatalf("expected halted [USDRUB], got: %v", got)
+	}
+}
+
+type breakersMock map[string]string
+
+func (m breakersMock) States() map[string]string { return m }
+func (m breakersMock) Reset(route string) bool {
+	if _, ok := m[route]; !ok {
+		return false
+	}
+	m[route] = "closed"
+	return true
+}
+
+func TestBreakers(t *testing.T) {
+	breakers := breakersMock{"default": "open", "USD*": "half-open"}
+	s := httptest.NewServer(NewHandler(WithBreakers(breakers)))
+	defer s.Close()
+
+	for _, req := range []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodDelete, "/breakers/default", http.StatusNoContent},
+		{http.MethodDelete, "/breakers/XLM*", http.StatusNotFound},
+		{http.MethodPut, "/breakers/default", http.StatusMethodNotAllowed},
+	} {
+		r, err := http.NewRequest(req.method, s.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != req.wantStatus {
+			t.Fatalf("%s %s: expected status %d, got: %d", req.method, req.path, req.wantStatus, res.StatusCode)
+		}
+	}
+
+	res, err := http.Get(s.URL + "/breakers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got map[string]string
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got["default"] != "closed" || got["USD*"] != "half-open" {
+		t.Fatalf("expected states of breakers, got: %v", got)
+	}
+}
+
+type blocksMock map[uint32]service.BlockMode
+
+func (m blocksMock) Block(clientID uint32, mode service.BlockMode) error {
+	m[clientID] = mode
+	return nil
+}
+func (m blocksMock) Unblock(clientID uint32) error {
+	delete(m, clientID)
+	return nil
+}
+func (m blocksMock) Blocks() map[uint32]service.BlockMode { return m }
+
+func TestBlocks(t *testing.T) {
+	blocks := blocksMock{}
+	s := httptest.NewServer(NewHandler(WithBlocks(blocks)))
+	defer s.Close()
+
+	for _, req := range []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodPut, "/blocks/4815", http.StatusNoContent},
+		{http.MethodPut, "/blocks/42?mode=all", http.StatusNoContent},
+		{http.MethodPut, "/blocks/16?mode=all", http.StatusNoContent},
+		{http.MethodDelete, "/blocks/16", http.StatusNoContent},
+		{http.MethodPut, "/blocks/23?mode=some", http.StatusBadRequest},
+		{http.MethodPut, "/blocks/client", http.StatusNotFound},
+		{http.MethodPost, "/blocks/23", http.StatusMethodNotAllowed},
+	} {
+		r, err := http.NewRequest(req.method, s.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != req.wantStatus {
+			t.Fatalf("%s %s: expected status %d, got: %d", req.method, req.path, req.wantStatus, res.StatusCode)
+		}
+	}
+
+	res, err := http.Get(s.URL + "/blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got map[uint32]service.BlockMode
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[4815] != service.BlockOpens || got[42] != service.BlockAll {
+		t.Fatalf("expected blocked clients, got: %v", got)
+	}
+}
+
+type closerMock struct {
+	closed []uint32
+}
+
+func (m *closerMock) CloseAll(clientID uint32) []handlers.ClosedOrder {
+	m.closed = append(m.closed, clientID)
+	return []handlers.ClosedOrder{{Instrument: "USDRUB", OrderKind: model.OrderKindBuy, Volume: 100}}
+}
+
+func TestBulkClose(t *testing.T) {
+	blocks := blocksMock{4815: service.BlockAll}
+	closer := &closerMock{}
+	s := httptest.NewServer(NewHandler(WithBlocks(blocks), WithBulkClose(closer)))
+	defer s.Close()
+
+	for _, req := range []struct {
+		method, path string
+		wantStatus   int
+	}{
+		{http.MethodPost, "/blocks/42/close", http.StatusConflict},
+		{http.MethodGet, "/blocks/4815/close", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/blocks/client/close", http.StatusNotFound},
+	} {
+		r, err := http.NewRequest(req.method, s.URL+req.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != req.wantStatus {
+			t.Fatalf("%s %s: expected status %d, got: %d", req.method, req.path, req.wantStatus, res.StatusCode)
+		}
+	}
+	if len(closer.closed) != 0 {
+		t.Fatalf("expected no bulk close, got: %v", closer.closed)
+	}
+
+	res, err := http.Post(s.URL+"/blocks/4815/close", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	var got []handlers.ClosedOrder
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	want := handlers.ClosedOrder{Instrument: "USDRUB", OrderKind: model.OrderKindBuy, Volume: 100}
+	if len(got) != 1 || got[0] != want || len(closer.closed) != 1 || closer.closed[0] != 4815 {
+		t.Fatalf("expected closes of client 4815, got: %v", got)
+	}
+}
+
+func TestBulkCloseDisabled(t *testing.T) {
+	s := httptest.NewServer(NewHandler(WithBlocks(blocksMock{4815: service.BlockAll})))
+	defer s.Close()
+
+	res, err := http.Post(s.URL+"/blocks/4815/close", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected status %d, got: %d", http.StatusNotFound, res.StatusCode)
+	}
+}
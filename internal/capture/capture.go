@@ -0,0 +1,120 @@
+package capture
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Direction tells which side of the proxy a captured frame belongs to.
+type Direction string
+
+const (
+	// DirectionClient is a frame received from a client.
+	DirectionClient Direction = "client"
+	// DirectionUpstream is a frame received from the order server.
+	DirectionUpstream Direction = "upstream"
+	// DirectionProxy is a response generated by the proxy itself,
+	// e.g. a rejection of an order that violates limits.
+	DirectionProxy Direction = "proxy"
+	// DirectionOpen marks the start of a session, its data is the
+	// subprotocol negotiated with the client
+	DirectionOpen Direction = "open"
+)
+
+// Frame is a single captured WebSocket frame
+type Frame struct {
+	// Time is the offset from the start of the capture
+	Time        time.Duration `json:"t"`
+	Session     uint64        `json:"session"`
+	Direction   Direction     `json:"dir"`
+	MessageType int           `json:"mt"`
+	Data        []byte        `json:"data"`
+}
+
+// Recorder writes captured frames as JSON lines
+type Recorder struct {
+	sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+	start  time.Time
+	now    func() time.Time
+}
+
+func NewRecorder(w io.Writer) *Recorder {
+	rec := &Recorder{
+		enc: json.NewEncoder(w),
+		now: time.Now,
+	}
+	rec.start = rec.now()
+	if c, ok := w.(io.Closer); ok {
+		rec.closer = c
+	}
+	return rec
+}
+
+// Create creates (or truncates) a capture file and returns a recorder writing to it
+func Create(path string) (*Recorder, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(f), nil
+}
+
+// Record stores a frame. Errors are only logged, capturing must never
+// affect the traffic going through the proxy.
+func (rec *Recorder) Record(session uint64, dir Direction, mt int, data []byte) {
+	rec.Lock()
+	defer rec.Unlock()
+	frame := Frame{
+		Time:        rec.now().Sub(rec.start),
+		Session:     session,
+		Direction:   dir,
+		MessageType: mt,
+		Data:        data,
+	}
+	if err := rec.enc.Encode(frame); err != nil {
+		log.Printf("capture frame: %v", err)
+	}
+}
+
+// Close closes the underlying writer if it's closable
+func (rec *Recorder) Close() error {
+	rec.Lock()
+	defer rec.Unlock()
+	if rec.closer == nil {
+		return nil
+	}
+	return rec.closer.Close()
+}
+
+// ReadFrames reads all frames written by a Recorder
+func ReadFrames(r io.Reader) ([]Frame, error) {
+	var frames []Frame
+	dec := json.NewDecoder(r)
+	for {
+		var frame Frame
+		err := dec.Decode(&frame)
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, frame)
+	}
+}
+
+// Load reads all frames from a capture file
+func Load(path string) ([]Frame, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadFrames(f)
+}
@@ -0,0 +1,42 @@
+package capture
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecordAndRead(t *testing.T) {
+	start := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	offsets := []time.Duration{0, 15 * time.Millisecond, time.Second}
+
+	var buf bytes.Buffer
+	rec := NewRecorder(&buf)
+	rec.start = start
+
+	want := []Frame{
+		{Time: offsets[0], Session: 1, Direction: DirectionClient, MessageType: 2, Data: []byte{1, 2, 3}},
+		{Time: offsets[1], Session: 1, Direction: DirectionProxy, MessageType: 2, Data: []byte{4, 5}},
+		{Time: offsets[2], Session: 2, Direction: DirectionUpstream, MessageType: 1, Data: []byte{6}},
+	}
+	for _, frame := range want {
+		offset := frame.Time
+		rec.now = func() time.Time { return start.Add(offset) }
+		rec.Record(frame.Session, frame.Direction, frame.MessageType, frame.Data)
+	}
+
+	got, err := ReadFrames(&buf)
+	if err != nil {
+		t.Fatalf("read frames: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected: %+v, got: %+v", want, got)
+	}
+}
+
+func TestReadFramesInvalid(t *testing.T) {
+	if _, err := ReadFrames(bytes.NewBufferString("{not json")); err == nil {
+		t.Fatal("expected error on malformed capture")
+	}
+}
@@ -0,0 +1,135 @@
+// Package config holds the configuration of the proxy. It's built of layers,
+// each overriding the previous one: defaults, a YAML or TOML file, PROXY_*
+// environment variables and flags.
+package config
+
+import (
+	"time"
+)
+
+// Config is the configuration of the proxy. Every setting has a flag named
+// by the flag tag and an environment variable named PROXY_ and the flag name
+// in upper snake case, like PROXY_MAX_SESSIONS_PER_IP for -maxSessionsPerIP.
+type Config struct {
+	Listener Listener `yaml:"listener" toml:"listener"`
+	Upgrader Upgrader `yaml:"upgrader" toml:"upgrader"`
+	Upstream Upstream `yaml:"upstream" toml:"upstream"`
+	Breaker  Breaker  `yaml:"breaker" toml:"breaker"`
+	Limits   Limits   `yaml:"limits" toml:"limits"`
+	Shadow   Shadow   `yaml:"shadow" toml:"shadow"`
+	Trading  Trading  `yaml:"trading" toml:"trading"`
+	Admin    Admin    `yaml:"admin" toml:"admin"`
+	Capture  string   `yaml:"capture" toml:"capture" flag:"capture" usage:"file to capture client and upstream frames to, disabled if empty"`
+}
+
+// Listener is the listener of client connections
+type Listener struct {
+	Addr             string  `yaml:"addr" toml:"addr" flag:"addr" usage:"http proxy address"`
+	TLSCert          string  `yaml:"tlsCert" toml:"tlsCert" flag:"tlsCert" usage:"TLS certificate file of the proxy listener, plain HTTP if empty"`
+	TLSKey           string  `yaml:"tlsKey" toml:"tlsKey" flag:"tlsKey" usage:"TLS key file of the proxy listener"`
+	MaxSessions      int     `yaml:"maxSessions" toml:"maxSessions" flag:"maxSessions" usage:"concurrent client sessions, unlimited if 0"`
+	MaxSessionsPerIP int     `yaml:"maxSessionsPerIP" toml:"maxSessionsPerIP" flag:"maxSessionsPerIP" usage:"concurrent client sessions from a source IP, unlimited if 0"`
+	UpgradeRate      float64 `yaml:"upgradeRate" toml:"upgradeRate" flag:"upgradeRate" usage:"WebSocket upgrades per second, unlimited if 0"`
+	UpgradeBurst     int     `yaml:"upgradeBurst" toml:"upgradeBurst" flag:"upgradeBurst" usage:"burst of WebSocket upgrades over the rate, the rate if 0"`
+	RejectWithClose  bool    `yaml:"rejectWithClose" toml:"rejectWithClose" flag:"rejectWithClose" usage:"reject connections beyond the limits with a close frame after the upgrade instead of HTTP 503"`
+}
+
+// Upgrader is the WebSocket upgrade of client connections
+type Upgrader struct {
+	AllowedOrigins   List   `yaml:"allowedOrigins" toml:"allowedOrigins" flag:"allowedOrigins" usage:"comma separated origins browsers may connect from, * allows any, the host's own if empty"`
+	RequiredHeaders  string `yaml:"requiredHeaders" toml:"requiredHeaders" flag:"requiredHeaders" usage:"comma separated headers required on upgrade like X-Api-Key=secret,X-Desk, any value if it's omitted"`
+	MaxMessageSize   int64  `yaml:"maxMessageSize" toml:"maxMessageSize" flag:"maxMessageSize" usage:"limit of a client frame in bytes, unlimited if 0"`
+	ReadBufferSize   int    `yaml:"readBufferSize" toml:"readBufferSize" flag:"readBufferSize" usage:"read buffer of client connections in bytes, 4096 if 0"`
+	WriteBufferSize  int    `yaml:"writeBufferSize" toml:"writeBufferSize" flag:"writeBufferSize" usage:"write buffer of client connections in bytes, 4096 if 0"`
+	Compression      bool   `yaml:"compression" toml:"compression" flag:"compression" usage:"negotiate permessage-deflate compression with clients"`
+	CompressionLevel int    `yaml:"compressionLevel" toml:"compressionLevel" flag:"compressionLevel" usage:"flate compression level of client connections from -2 to 9, the default if 0"`
+}
+
+// Upstream is the connections to order servers
+type Upstream struct {
+	Backends       List          `yaml:"backends" toml:"backends" flag:"backendAddr" usage:"comma separated order server addresses, the first healthy one is used"`
+	Sticky         bool          `yaml:"sticky" toml:"sticky" flag:"backendSticky" usage:"spread clients over healthy order servers by client ID"`
+	Routes         string        `yaml:"routes" toml:"routes" flag:"routes" usage:"comma separated instrument routes to order servers like USD*=host1:8081|host2:8081,XLMEUR=host3:8081, other instruments go to -backendAddr"`
+	HealthInterval time.Duration `yaml:"healthInterval" toml:"healthInterval" flag:"healthInterval" usage:"interval of order server health checks"`
+	Timeout        time.Duration `yaml:"timeout" toml:"timeout" flag:"upstreamTimeout" usage:"time the order server has to answer a request before the client gets a failure, waits forever if 0"`
+	Batch          bool          `yaml:"batch" toml:"batch" flag:"upstreamBatch" usage:"pass batch frames to the order server as batches if it supports them"`
+	Inflight       int           `yaml:"inflight" toml:"inflight" flag:"inflight" usage:"requests per client session the order server hasn't answered yet, unbounded if 0"`
+	InflightQueue  int           `yaml:"inflightQueue" toml:"inflightQueue" flag:"inflightQueue" usage:"requests per client session waiting for the in-flight window, the rest are rejected"`
+}
+
+// Breaker is the circuit breakers of upstream routes
+type Breaker struct {
+	Rate        float64       `yaml:"rate" toml:"rate" flag:"breakerRate" usage:"share of failed upstream requests tripping the circuit breaker of a route, like 0.5, disabled if 0"`
+	Window      int           `yaml:"window" toml:"window" flag:"breakerWindow" usage:"number of the latest upstream requests the breaker failure rate is counted over"`
+	MinRequests int           `yaml:"minRequests" toml:"minRequests" flag:"breakerMinRequests" usage:"number of upstream requests in the window before the breaker can trip"`
+	OpenFor     time.Duration `yaml:"openFor" toml:"openFor" flag:"breakerOpenFor" usage:"time the breaker fails requests before probing the order server"`
+	Probes      int           `yaml:"probes" toml:"probes" flag:"breakerProbes" usage:"number of probe requests closing the half-open breaker"`
+}
+
+// Limits is the risk limits of orders
+type Limits struct {
+	N              uint    `yaml:"N" toml:"N" flag:"N" usage:"opened orders per client per instrument"`
+	S              float64 `yaml:"S" toml:"S" flag:"S" usage:"sum of volumes per client per instrument"`
+	Net            float64 `yaml:"net" toml:"net" flag:"netLimit" usage:"net exposure (buys minus sells) per client per instrument, disabled if 0"`
+	Gross          float64 `yaml:"gross" toml:"gross" flag:"grossLimit" usage:"gross exposure (buys plus sells) per client per instrument, disabled if 0"`
+	ClientN        uint    `yaml:"clientN" toml:"clientN" flag:"clientN" usage:"opened orders per client over all instruments, disabled if 0"`
+	ClientS        float64 `yaml:"clientS" toml:"clientS" flag:"clientS" usage:"sum of volumes per client over all instruments, disabled if 0"`
+	Currency       float64 `yaml:"currency" toml:"currency" flag:"currencyLimit" usage:"sum of volumes per client per currency of instruments like USDRUB, disabled if 0"`
+	InstrumentN    uint    `yaml:"instrumentN" toml:"instrumentN" flag:"instrumentN" usage:"opened orders per instrument over all clients, disabled if 0"`
+	InstrumentS    float64 `yaml:"instrumentS" toml:"instrumentS" flag:"instrumentS" usage:"sum of volumes per instrument over all clients, disabled if 0"`
+	MaxOrderVolume float64 `yaml:"maxOrderVolume" toml:"maxOrderVolume" flag:"maxOrderVolume" usage:"volume of a single order, disabled if 0"`
+	Blacklist      List    `yaml:"blacklist" toml:"blacklist" flag:"blacklist" usage:"comma separated instruments new orders can't be opened on"`
+	Windows        string  `yaml:"windows" toml:"windows" flag:"windows" usage:"comma separated period:limit of volume opened per client per instrument, like 1m:10000,24h:100000"`
+	BatchAtomic    bool    `yaml:"batchAtomic" toml:"batchAtomic" flag:"batchAtomic" usage:"reject the whole batch frame if any of its orders is rejected"`
+}
+
+// Shadow is the limits only logged and counted
+type Shadow struct {
+	N       uint    `yaml:"N" toml:"N" flag:"shadowN" usage:"shadow limit of opened orders per client per instrument, only logged and counted, disabled if 0"`
+	S       float64 `yaml:"S" toml:"S" flag:"shadowS" usage:"shadow limit of sum of volumes per client per instrument, only logged and counted, disabled if 0"`
+	Windows string  `yaml:"windows" toml:"windows" flag:"shadowWindows" usage:"shadow windows of volume opened per client per instrument, only logged and counted"`
+}
+
+// Trading is the trading sessions of instruments
+type Trading struct {
+	Schedules       string `yaml:"schedules" toml:"schedules" flag:"schedules" usage:"JSON file with trading sessions per instrument, traded around the clock if empty"`
+	CloseWhenHalted bool   `yaml:"closeWhenHalted" toml:"closeWhenHalted" flag:"closeWhenHalted" usage:"allow close orders on halted instruments and out of trading sessions"`
+	Instruments     List   `yaml:"instruments" toml:"instruments" flag:"instruments" usage:"comma separated instruments traded through the proxy, their names are reused when decoding orders along with the ones of -schedules, -blacklist and -routes"`
+}
+
+// Admin is the operator API
+type Admin struct {
+	Addr   string `yaml:"addr" toml:"addr" flag:"adminAddr" usage:"http address of admin API and metrics, disabled if empty"`
+	Blocks string `yaml:"blocks" toml:"blocks" flag:"blocks" usage:"file clients blocked by the kill switch are saved to, not saved if empty"`
+}
+
+// Default returns the configuration used unless overridden. Features the
+// proxy didn't have before, like upstream timeouts, the admin API and saved
+// blocks, are disabled, so it behaves the same without a configuration.
+func Default() Config {
+	return Config{
+		Listener: Listener{
+			Addr: "localhost:8080",
+		},
+		Upgrader: Upgrader{
+			MaxMessageSize: 64 << 10,
+		},
+		Upstream: Upstream{
+			Backends:       List{"localhost:8081"},
+			HealthInterval: 2 * time.Second,
+		},
+		Breaker: Breaker{
+			Window:      20,
+			MinRequests: 10,
+			OpenFor:     10 * time.Second,
+			Probes:      3,
+		},
+		Limits: Limits{
+			N: 4,
+			S: 4400,
+		},
+		Trading: Trading{
+			CloseWhenHalted: true,
+		},
+	}
+}
@@ -0,0 +1,174 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadLayers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"proxy.yaml": `
+listener:
+  maxSessions: 100
+upstream:
+  backends: [a:8081, b:8081]
+  timeout: 3s
+limits:
+  N: 10
+  S: 1000
+`,
+		"proxy.toml": `
+[listener]
+maxSessions = 100
+[upstream]
+backends = ["a:8081", "b:8081"]
+timeout = "3s"
+[limits]
+N = 10
+S = 1000
+`,
+	}
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			env := map[string]string{
+				"PROXY_CONFIG": path,
+				"PROXY_N":      "20",
+				"PROXY_S":      "2000",
+			}
+			lookupEnv := func(name string) (string, bool) {
+				v, ok := env[name]
+				return v, ok
+			}
+
+			cfg, printConfig, err := Load("proxy", []string{"-S", "3000", "-print-config"}, lookupEnv)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !printConfig {
+				t.Fatal("expected -print-config to be set")
+			}
+			// defaults
+			if cfg.Listener.Addr != "localhost:8080" || cfg.Breaker.Window != 20 {
+				t.Fatalf("expected defaults to stay, got %+v", cfg)
+			}
+			// file over defaults
+			if cfg.Listener.MaxSessions != 100 || cfg.Upstream.Timeout != 3*time.Second ||
+				strings.Join(cfg.Upstream.Backends, ",") != "a:8081,b:8081" {
+				t.Fatalf("expected settings of the file, got %+v", cfg)
+			}
+			// environment over the file
+			if cfg.Limits.N != 20 {
+				t.Fatalf("expected N of the environment, got %d", cfg.Limits.N)
+			}
+			// flags over the environment
+			if cfg.Limits.S != 3000 {
+				t.Fatalf("expected S of the flags, got %g", cfg.Limits.S)
+			}
+			if err := cfg.Validate(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	t.Run("unknown setting", func(t *testing.T) {
+		for name, content := range map[string]string{
+			"unknown.yaml": "limits:\n  M: 1\n",
+			"unknown.toml": "[limits]\nM = 1\n",
+		} {
+			path := filepath.Join(dir, name)
+			if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := Load("proxy", []string{"-config", path}, noEnv); err == nil {
+				t.Fatalf("%s: expected unknown setting to fail", name)
+			}
+		}
+	})
+
+	t.Run("invalid environment", func(t *testing.T) {
+		lookupEnv := func(name string) (string, bool) {
+			return "soon", name == "PROXY_UPSTREAM_TIMEOUT"
+		}
+		_, _, err := Load("proxy", nil, lookupEnv)
+		if err == nil || !strings.Contains(err.Error(), "PROXY_UPSTREAM_TIMEOUT") {
+			t.Fatalf("expected error naming the variable, got: %v", err)
+		}
+	})
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]string{
+		"N":                "PROXY_N",
+		"addr":             "PROXY_ADDR",
+		"maxSessionsPerIP": "PROXY_MAX_SESSIONS_PER_IP",
+		"tlsCert":          "PROXY_TLS_CERT",
+		"backendAddr":      "PROXY_BACKEND_ADDR",
+		"shadowN":          "PROXY_SHADOW_N",
+	}
+	for flagName, want := range cases {
+		if got := EnvName(flagName); got != want {
+			t.Fatalf("%s: expected %s, got %s", flagName, want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cases := []struct {
+		name    string
+		modify  func(c *Config)
+		wantErr string
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"no backends", func(c *Config) { c.Upstream.Backends = nil }, "backendAddr is empty"},
+		{"N zero", func(c *Config) { c.Limits.N = 0 }, ""},
+		{"tls key", func(c *Config) { c.Listener.TLSCert = "cert.pem" }, "tlsCert and tlsKey"},
+		{"breaker rate", func(c *Config) { c.Breaker.Rate = 1.5 }, "breakerRate 1.5"},
+		{"breaker probes", func(c *Config) { c.Breaker.Rate, c.Breaker.Probes = 0.5, 0 }, "breakerProbes"},
+		{"breaker disabled", func(c *Config) { c.Breaker.Probes = 0 }, ""},
+		{"breaker timeout", func(c *Config) { c.Breaker.Rate, c.Upstream.Timeout = 0.5, 0 }, "upstreamTimeout must be positive"},
+		{"compression level", func(c *Config) { c.Upgrader.CompressionLevel = 10 }, "compressionLevel 10"},
+		{"windows", func(c *Config) { c.Limits.Windows = "1m" }, "windows: invalid window"},
+		{"window period", func(c *Config) { c.Limits.Windows = "0s:1000" }, "period must be positive"},
+		{"window limit", func(c *Config) { c.Shadow.Windows = "1m:-5" }, "shadowWindows: invalid window \"1m:-5\": limit must be positive"},
+		{"routes", func(c *Config) { c.Upstream.Routes = "USD*" }, "invalid route"},
+		{"negative", func(c *Config) { c.Listener.MaxSessions = -1 }, "maxSessions is negative"},
+	}
+	for _, tc := range cases {
+		cfg := Default()
+		tc.modify(&cfg)
+		err := cfg.Validate()
+		if tc.wantErr == "" {
+			if err != nil {
+				t.Fatalf("%s: expected valid configuration, got: %v", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Fatalf("%s: expected %q, got: %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	cfg := Default()
+	cfg.Listener.MaxSessions, cfg.Limits.S = -1, 0
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "S must be positive; maxSessions is negative") {
+		t.Fatalf("expected all errors listed, got: %v", err)
+	}
+}
+
+func noEnv(string) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix is the prefix of environment variables of settings
+const EnvPrefix = "PROXY_"
+
+// List is a list of values, comma separated in flags and environment variables
+type List []string
+
+func (l *List) String() string {
+	if l == nil {
+		return ""
+	}
+	return strings.Join(*l, ",")
+}
+
+func (l *List) Set(s string) error {
+	*l = nil
+	if s != "" {
+		*l = strings.Split(s, ",")
+	}
+	return nil
+}
+
+// Load builds the configuration of the layers: defaults, the file passed with
+// -config or PROXY_CONFIG, environment variables and flags. It tells whether
+// -print-config is set. The configuration isn't validated.
+func Load(name string, args []string, lookupEnv func(string) (string, bool)) (Config, bool, error) {
+	// the file and the environment are under flags, so flags are parsed
+	// once to find the file and again on top of the loaded layers
+	scratch := Default()
+	path, printConfig := "", false
+	fs := newFlagSet(name, &scratch, &path, &printConfig)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	if env, ok := lookupEnv(EnvPrefix + "CONFIG"); ok && path == "" {
+		path = env
+	}
+
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return Config{}, false, err
+		}
+	}
+	if err := loadEnv(&cfg, lookupEnv); err != nil {
+		return Config{}, false, err
+	}
+	fs = newFlagSet(name, &cfg, &path, &printConfig)
+	if err := fs.Parse(args); err != nil {
+		return Config{}, false, err
+	}
+	return cfg, printConfig, nil
+}
+
+// YAML returns the configuration in the format of a configuration file
+func (c Config) YAML() ([]byte, error) {
+	return yaml.Marshal(c)
+}
+
+func newFlagSet(name string, cfg *Config, path *string, printConfig *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.StringVar(path, "config", "", "YAML or TOML configuration file, overridden by environment variables and flags")
+	fs.BoolVar(printConfig, "print-config", false, "print the effective configuration and exit")
+	bind(fs, cfg)
+	return fs
+}
+
+// bind defines flags of the settings of the configuration,
+// their defaults are the current values
+func bind(fs *flag.FlagSet, cfg *Config) {
+	settings(cfg, func(f reflect.StructField, v reflect.Value) {
+		name, usage := f.Tag.Get("flag"), f.Tag.Get("usage")
+		switch p := v.Addr().Interface().(type) {
+		case *List:
+			fs.Var(p, name, usage)
+		case *time.Duration:
+			fs.DurationVar(p, name, *p, usage)
+		case *string:
+			fs.StringVar(p, name, *p, usage)
+		case *bool:
+			fs.BoolVar(p, name, *p, usage)
+		case *int:
+			fs.IntVar(p, name, *p, usage)
+		case *int64:
+			fs.Int64Var(p, name, *p, usage)
+		case *uint:
+			fs.UintVar(p, name, *p, usage)
+		case *float64:
+			fs.Float64Var(p, name, *p, usage)
+		default:
+			panic(fmt.Sprintf("config: unsupported setting %s of type %s", name, v.Type()))
+		}
+	})
+}
+
+// loadFile decodes the file over the configuration by its extension,
+// unknown settings are errors
+func loadFile(path string, cfg *Config) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dec := yaml.NewDecoder(f)
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+	case ".toml":
+		md, err := toml.DecodeFile(path, cfg)
+		if err != nil {
+			return fmt.Errorf("decode %s: %w", path, err)
+		}
+		if undecoded := md.Undecoded(); len(undecoded) > 0 {
+			return fmt.Errorf("decode %s: unknown setting %s", path, undecoded[0])
+		}
+	default:
+		return fmt.Errorf("unknown format of configuration file %s, expected .yaml, .yml or .toml", path)
+	}
+	return nil
+}
+
+// loadEnv sets the settings from environment variables
+// parsed the same way as flags
+func loadEnv(cfg *Config, lookupEnv func(string) (string, bool)) error {
+	fs := flag.NewFlagSet("env", flag.ContinueOnError)
+	bind(fs, cfg)
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		name := EnvName(f.Name)
+		s, ok := lookupEnv(name)
+		if !ok || err != nil {
+			return
+		}
+		if setErr := fs.Set(f.Name, s); setErr != nil {
+			err = fmt.Errorf("%s: %w", name, setErr)
+		}
+	})
+	return err
+}
+
+// EnvName returns the environment variable of the flag,
+// like PROXY_MAX_SESSIONS_PER_IP for maxSessionsPerIP
+func EnvName(flagName string) string {
+	runes := []rune(flagName)
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) && (unicode.IsLower(runes[i-1]) ||
+			i+1 < len(runes) && unicode.IsLower(runes[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToUpper(r))
+	}
+	return b.String()
+}
+
+// settings calls fn for every setting of the configuration,
+// which is a field with the flag tag
+func settings(cfg *Config, fn func(f reflect.StructField, v reflect.Value)) {
+	var walk func(v reflect.Value)
+	walk = func(v reflect.Value) {
+		for i := 0; i < v.NumField(); i++ {
+			f, fv := v.Type().Field(i), v.Field(i)
+			if _, ok := f.Tag.Lookup("flag"); ok {
+				fn(f, fv)
+				continue
+			}
+			if fv.Kind() == reflect.Struct {
+				walk(fv)
+			}
+		}
+	}
+	walk(reflect.ValueOf(cfg).Elem())
+}
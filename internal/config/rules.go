@@ -0,0 +1,64 @@
+package config
+
+import (
+	"test.task/backend/proxy/internal/service"
+)
+
+// Rules builds the chain of rules checked after N and S
+func (l Limits) Rules() ([]service.Rule, error) {
+	var res []service.Rule
+	if len(l.Blacklist) > 0 {
+		res = append(res, service.NewBlacklist(l.Blacklist...))
+	}
+	if l.MaxOrderVolume > 0 {
+		res = append(res, service.MaxOrderVolume(l.MaxOrderVolume))
+	}
+	if l.Net > 0 {
+		res = append(res, service.NetExposureLimit(l.Net))
+	}
+	if l.Gross > 0 {
+		res = append(res, service.GrossExposureLimit(l.Gross))
+	}
+	if l.ClientN > 0 {
+		res = append(res, service.ClientOrdersLimit(l.ClientN))
+	}
+	if l.ClientS > 0 {
+		res = append(res, service.ClientVolumeLimit(l.ClientS))
+	}
+	if l.Currency > 0 {
+		res = append(res, service.CurrencyLimit(l.Currency))
+	}
+	if l.InstrumentN > 0 {
+		res = append(res, service.InstrumentOrdersLimit(l.InstrumentN))
+	}
+	if l.InstrumentS > 0 {
+		res = append(res, service.InstrumentVolumeLimit(l.InstrumentS))
+	}
+	windows, err := ParseWindows(l.Windows)
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) > 0 {
+		res = append(res, service.NewVolumeWindows(windows...))
+	}
+	return res, nil
+}
+
+// Rules builds the rules which never reject
+func (s Shadow) Rules() ([]service.Rule, error) {
+	var res []service.Rule
+	if s.N > 0 {
+		res = append(res, service.OrdersLimit(s.N))
+	}
+	if s.S > 0 {
+		res = append(res, service.VolumeLimit(s.S))
+	}
+	windows, err := ParseWindows(s.Windows)
+	if err != nil {
+		return nil, err
+	}
+	if len(windows) > 0 {
+		res = append(res, service.NewVolumeWindows(windows...))
+	}
+	return res, nil
+}
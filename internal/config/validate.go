@@ -0,0 +1,157 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"test.task/backend/proxy/internal/service"
+)
+
+// Route sends orders on instruments matching the pattern to the backends
+type Route struct {
+	Pattern  string
+	Backends []string
+}
+
+// Validate returns an error listing all invalid settings
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(c.Listener.Addr != "", "addr is empty")
+	check((c.Listener.TLSCert == "") == (c.Listener.TLSKey == ""), "tlsCert and tlsKey must be set together")
+	check(c.Listener.MaxSessions >= 0, "maxSessions is negative")
+	check(c.Listener.MaxSessionsPerIP >= 0, "maxSessionsPerIP is negative")
+	check(c.Listener.UpgradeRate >= 0, "upgradeRate is negative")
+	check(c.Listener.UpgradeBurst >= 0, "upgradeBurst is negative")
+
+	if _, err := ParseHeaders(c.Upgrader.RequiredHeaders); err != nil {
+		errs = append(errs, err.Error())
+	}
+	check(c.Upgrader.MaxMessageSize >= 0, "maxMessageSize is negative")
+	check(c.Upgrader.ReadBufferSize >= 0, "readBufferSize is negative")
+	check(c.Upgrader.WriteBufferSize >= 0, "writeBufferSize is negative")
+	check(c.Upgrader.CompressionLevel >= -2 && c.Upgrader.CompressionLevel <= 9, "compressionLevel %d is out of -2..9", c.Upgrader.CompressionLevel)
+
+	check(len(c.Upstream.Backends) > 0, "backendAddr is empty")
+	for _, addr := range c.Upstream.Backends {
+		check(addr != "", "backendAddr has an empty address")
+	}
+	if _, err := ParseRoutes(c.Upstream.Routes); err != nil {
+		errs = append(errs, err.Error())
+	}
+	check(c.Upstream.HealthInterval > 0, "healthInterval must be positive")
+	check(c.Upstream.Timeout >= 0, "upstreamTimeout is negative")
+	check(c.Upstream.Inflight >= 0, "inflight is negative")
+	check(c.Upstream.InflightQueue >= 0, "inflightQueue is negative")
+
+	check(c.Breaker.Rate >= 0 && c.Breaker.Rate <= 1, "breakerRate %g is out of 0..1", c.Breaker.Rate)
+	if c.Breaker.Rate > 0 {
+		check(c.Breaker.Window > 0, "breakerWindow must be positive")
+		check(c.Breaker.MinRequests >= 0, "breakerMinRequests is negative")
+		check(c.Breaker.OpenFor > 0, "breakerOpenFor must be positive")
+		check(c.Breaker.Probes > 0, "breakerProbes must be positive")
+		// requests of a server that never answers fail only on timeout
+		check(c.Upstream.Timeout > 0, "upstreamTimeout must be positive with breakers")
+	}
+
+	// N of 0 is valid and rejects every open
+	check(c.Limits.S > 0, "S must be positive")
+	for name, v := range map[string]float64{
+		"netLimit":       c.Limits.Net,
+		"grossLimit":     c.Limits.Gross,
+		"clientS":        c.Limits.ClientS,
+		"currencyLimit":  c.Limits.Currency,
+		"instrumentS":    c.Limits.InstrumentS,
+		"maxOrderVolume": c.Limits.MaxOrderVolume,
+		"shadowS":        c.Shadow.S,
+	} {
+		check(v >= 0, "%s is negative", name)
+	}
+	for name, windows := range map[string]string{"windows": c.Limits.Windows, "shadowWindows": c.Shadow.Windows} {
+		if _, err := ParseWindows(windows); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	// limits are checked in random order of the map
+	sort.Strings(errs)
+	return errors.New("invalid configuration: " + strings.Join(errs, "; "))
+}
+
+// ParseWindows parses windows like 1m:10000,24h:100000
+func ParseWindows(s string) ([]service.Window, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var res []service.Window
+	for _, w := range strings.Split(s, ",") {
+		parts := strings.Split(w, ":")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid window %q, expected period:limit", w)
+		}
+		period, err := time.ParseDuration(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", w, err)
+		}
+		limit, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid window %q: %w", w, err)
+		}
+		if period <= 0 {
+			return nil, fmt.Errorf("invalid window %q: period must be positive", w)
+		}
+		if limit <= 0 {
+			return nil, fmt.Errorf("invalid window %q: limit must be positive", w)
+		}
+		res = append(res, service.Window{Period: period, Limit: limit})
+	}
+	return res, nil
+}
+
+// ParseRoutes parses routes like USD*=host1:8081|host2:8081,XLMEUR=host3:8081
+func ParseRoutes(s string) ([]Route, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var res []Route
+	for _, r := range strings.Split(s, ",") {
+		parts := strings.Split(r, "=")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid route %q, expected pattern=addr|addr", r)
+		}
+		res = append(res, Route{Pattern: parts[0], Backends: strings.Split(parts[1], "|")})
+	}
+	return res, nil
+}
+
+// ParseHeaders parses headers like X-Api-Key=secret,X-Desk
+func ParseHeaders(s string) (map[string]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	res := make(map[string]string)
+	for _, h := range strings.Split(s, ",") {
+		parts := strings.SplitN(h, "=", 2)
+		if parts[0] == "" {
+			return nil, fmt.Errorf("invalid header %q, expected name=value or name", h)
+		}
+		if len(parts) == 1 {
+			res[parts[0]] = ""
+			continue
+		}
+		res[parts[0]] = parts[1]
+	}
+	return res, nil
+}
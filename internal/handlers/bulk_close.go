@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/model"
+)
+
+// bulkCloseTimeout is the time the servers have to answer closes of a bulk
+// close when upstream timeouts are disabled
+const bulkCloseTimeout = 5 * time.Second
+
+// ClosedOrder is a close sent by a bulk close and the code it was answered with
+type ClosedOrder struct {
+	Instrument string           `json:"instrument"`
+	OrderKind  model.OrderKind  `json:"orderKind"`
+	Volume     float64          `json:"volume"`
+	Code       model.ResultCode `json:"code"`
+}
+
+// CloseAll flattens open positions of the client: closes are sent to the
+// servers on the client's behalf, over connections of their own, and the ones
+// the servers accept are applied bypassing the limits and the kill switch.
+// Closes not answered in time keep their positions open.
+func (p *ProxyHandler) CloseAll(clientID uint32) []ClosedOrder {
+	orders := p.ordersSvc.ClosingOrders(clientID)
+	res := make([]ClosedOrder, len(orders))
+	var (
+		routes   []string
+		routed   = make(map[string][]int)
+		backends = make(map[string]backendPool)
+	)
+	for i := range orders {
+		orders[i].ID = uint32(i + 1)
+		res[i] = ClosedOrder{
+			Instrument: orders[i].Instrument,
+			OrderKind:  orders[i].OrderKind,
+			Volume:     orders[i].Volume,
+			Code:       model.ResultCodeUpstreamTimeout,
+		}
+		route, pool := p.router.Route(orders[i].Instrument)
+		if _, ok := routed[route]; !ok {
+			routes = append(routes, route)
+			backends[route] = pool
+		}
+		routed[route] = append(routed[route], i)
+	}
+
+	var wg sync.WaitGroup
+	for _, route := range routes {
+		wg.Add(1)
+		go func(idx []int, backends backendPool) {
+			defer wg.Done()
+			reqs := make([]model.OrderRequest, len(idx))
+			for j, i := range idx {
+				reqs[j] = orders[i]
+			}
+			codes := p.sendCloses(clientID, backends, reqs)
+			for _, i := range idx {
+				if code, ok := codes[orders[i].ID]; ok {
+					res[i].Code = code
+				}
+			}
+		}(routed[route], backends[route])
+	}
+	wg.Wait()
+
+	for i, order := range orders {
+		if res[i].Code == model.ResultCodeSuccess {
+			p.ordersSvc.ForceClose(order)
+		}
+		log.Printf("bulk close of client %d: kind %d volume %v on %s: code %d",
+			clientID, order.OrderKind, order.Volume, order.Instrument, res[i].Code)
+	}
+	return res
+}
+
+// sendCloses sends the closes to the backends and returns codes of the ones
+// answered in time by ID, closes the server can't take get
+// ResultCodeUpstreamUnavailable. Breakers are left alone: operators close
+// positions whatever state the route is in.
+func (p *ProxyHandler) sendCloses(clientID uint32, backends backendPool, orders []model.OrderRequest) map[uint32]model.ResultCode {
+	codes := make(map[uint32]model.ResultCode, len(orders))
+	conn, err := p.getServerConn(clientID, backends)
+	if err != nil {
+		log.Printf("bulk close of client %d: %v", clientID, err)
+		for _, order := range orders {
+			codes[order.ID] = model.ResultCodeUpstreamUnavailable
+		}
+		return codes
+	}
+	defer conn.ws.Close()
+
+	reqs := make([]proxy.OrderRequest, len(orders))
+	sent := make(map[uint32]bool, len(orders))
+	for i, order := range orders {
+		sent[order.ID] = true
+		reqs[i] = proxy.OrderRequest{
+			ClientID:   order.ClientID,
+			ID:         order.ID,
+			ReqType:    uint8(order.ReqType),
+			OrderKind:  uint8(order.OrderKind),
+			Volume:     order.Volume,
+			Instrument: order.Instrument,
+		}
+	}
+	for _, req := range writeCloses(conn, reqs) {
+		codes[req.ID] = model.ResultCodeUpstreamUnavailable
+		delete(sent, req.ID)
+	}
+
+	timeout := p.upstreamTimeout
+	if timeout == 0 {
+		timeout = bulkCloseTimeout
+	}
+	conn.ws.SetReadDeadline(time.Now().Add(timeout))
+	for len(sent) > 0 {
+		_, message, err := conn.ws.ReadMessage()
+		if err != nil {
+			log.Printf("bulk close of client %d: read from server: %v", clientID, err)
+			break
+		}
+		responses, err := decodeServerResponses(conn.ws, message)
+		if err != nil {
+			log.Printf("bulk close of client %d: decode server response: %v", clientID, err)
+			continue
+		}
+		for _, r := range responses {
+			if sent[r.ID] {
+				codes[r.ID] = model.ResultCode(r.Code)
+				delete(sent, r.ID)
+			}
+		}
+	}
+	return codes
+}
+
+// writeCloses sends the closes to the server, in a single frame if batching
+// was negotiated with it, and returns the ones it failed to take
+func writeCloses(conn *upstreamConn, reqs []proxy.OrderRequest) []proxy.OrderRequest {
+	if conn.ws.Subprotocol() == proxy.SubprotocolBatch {
+		message, err := proxy.EncodeOrderRequestBatch(reqs)
+		if err != nil {
+			log.Printf("encode batch: %v", err)
+			return reqs
+		}
+		if err := writeToConn(conn.ws, "server", websocket.BinaryMessage, message); err != nil {
+			return reqs
+		}
+		return nil
+	}
+	var failed []proxy.OrderRequest
+	for _, req := range reqs {
+		if err := writeToConn(conn.ws, "server", websocket.BinaryMessage, proxy.EncodeOrderRequest(req)); err != nil {
+			failed = append(failed, req)
+		}
+	}
+	return failed
+}
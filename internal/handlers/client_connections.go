@@ -9,18 +9,42 @@ import (
 // filterConnection filters initiated connection and returns true if everything
 // is ok, otherwise returns false and closes the connection with a client.
 func (p *ProxyHandler) filterConnection(clientWS *websocket.Conn, clientID uint32) bool {
+	if p.killSwitch != nil && p.isBlocked(clientID) {
+		log.Printf("client %d is blocked", clientID)
+		closeConn(clientWS, websocket.ClosePolicyViolation, "client is blocked")
+		clientWS.Close()
+		return false
+	}
 	if !p.clientsSvc.TryConnectClient(clientID) {
 		log.Printf("client %d is already connected", clientID)
-		if err := clientWS.WriteMessage(
-			websocket.CloseMessage,
-			websocket.FormatCloseMessage(
-				websocket.CloseNormalClosure,
-				"",
-			)); err != nil {
-			log.Println("write close:", err)
-			return false
-		}
+		closeConn(clientWS, websocket.CloseNormalClosure, "")
 		return false
 	}
 	return true
 }
+
+// isBlocked tells whether the kill switch blocks the client entirely
+func (p *ProxyHandler) isBlocked(clientID uint32) bool {
+	kicked, release := p.killSwitch.Kicked(clientID)
+	defer release()
+	return isClosed(kicked)
+}
+
+// closeConn sends a close frame with the code and reason to the peer
+func closeConn(conn *websocket.Conn, code int, reason string) {
+	if err := conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+	); err != nil {
+		log.Println("write close:", err)
+	}
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	proxy "test.task/backend/proxy"
+)
+
+// clientCodec translates between the wire format negotiated with a client
+// and the binary protocol spoken with the order server
+type clientCodec interface {
+	// decodeRequests returns requests carried by a single client frame
+	decodeRequests(message []byte) ([]proxy.OrderRequest, error)
+	// encodeResponses returns frames carrying the responses
+	encodeResponses(res []proxy.OrderResponse) ([][]byte, error)
+	// subprotocol is the name of the encoding
+	subprotocol() string
+}
+
+// codecFor returns codec for the subprotocol negotiated on upgrade
+func codecFor(subprotocol string) clientCodec {
+	switch subprotocol {
+	case proxy.SubprotocolJSON:
+		return jsonCodec{}
+	case proxy.SubprotocolBatch:
+		return batchCodec{}
+	default:
+		return &binaryCodec{}
+	}
+}
+
+// binaryCodec decodes requests into a buffer of the session, the requests
+// are valid until the next frame is decoded
+type binaryCodec struct {
+	reqs [1]proxy.OrderRequest
+}
+
+func (*binaryCodec) subprotocol() string { return proxy.SubprotocolBinary }
+
+func (c *binaryCodec) decodeRequests(message []byte) ([]proxy.OrderRequest, error) {
+	if err := proxy.DecodeOrderRequestInto(message, &c.reqs[0]); err != nil {
+		return nil, err
+	}
+	return c.reqs[:], nil
+}
+
+func (*binaryCodec) encodeResponses(res []proxy.OrderResponse) ([][]byte, error) {
+	messages := make([][]byte, len(res))
+	for i := range res {
+		messages[i] = proxy.EncodeOrderResponse(res[i])
+	}
+	return messages, nil
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) subprotocol() string { return proxy.SubprotocolJSON }
+
+func (jsonCodec) decodeRequests(message []byte) ([]proxy.OrderRequest, error) {
+	req, err := proxy.DecodeOrderRequestJSON(message)
+	if err != nil {
+		return nil, err
+	}
+	return []proxy.OrderRequest{req}, nil
+}
+
+func (jsonCodec) encodeResponses(res []proxy.OrderResponse) ([][]byte, error) {
+	messages := make([][]byte, len(res))
+	for i := range res {
+		m, err := proxy.EncodeOrderResponseJSON(res[i])
+		if err != nil {
+			return nil, err
+		}
+		messages[i] = m
+	}
+	return messages, nil
+}
+
+// batchCodec carries any number of requests and responses in a single frame
+type batchCodec struct{}
+
+func (batchCodec) subprotocol() string { return proxy.SubprotocolBatch }
+
+func (batchCodec) decodeRequests(message []byte) ([]proxy.OrderRequest, error) {
+	return proxy.DecodeOrderRequestBatch(message)
+}
+
+func (batchCodec) encodeResponses(res []proxy.OrderResponse) ([][]byte, error) {
+	m, err := proxy.EncodeOrderResponseBatch(res)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{m}, nil
+}
@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"errors"
+
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/capture"
+)
+
+var errUnsupportedVersion = errors.New("unsupported protocol version")
+
+// handshake answers the hello frame of a client: selects the protocol version
+// and the codec of the session and tells the client what was accepted
+func (p *ProxyHandler) handshake(s *session, hello proxy.Hello) error {
+	if hello.Version < proxy.ProtocolV1 {
+		return errUnsupportedVersion
+	}
+	version := hello.Version
+	if version > proxy.CurrentVersion {
+		version = proxy.CurrentVersion
+	}
+
+	// batch and JSON encodings can't be combined, batch takes precedence.
+	// Clients accepting neither speak the binary protocol, whatever
+	// subprotocol was negotiated on upgrade.
+	var accepted proxy.Capability
+	switch {
+	case hello.Capabilities.Has(proxy.CapabilityBatch):
+		accepted = proxy.CapabilityBatch
+		s.codec = batchCodec{}
+	case hello.Capabilities.Has(proxy.CapabilityJSON):
+		accepted = proxy.CapabilityJSON
+		s.codec = jsonCodec{}
+	default:
+		s.codec = codecFor("")
+	}
+	s.version = version
+	p.record(s, capture.DirectionOpen, 0, []byte(s.codec.subprotocol()))
+
+	ack := proxy.EncodeHello(proxy.Hello{
+		Version:      version,
+		Capabilities: accepted,
+	})
+	p.record(s, capture.DirectionProxy, s.clientFrameType(), ack)
+	return s.writeToClient(ack)
+}
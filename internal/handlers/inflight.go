@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"sync"
+
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/model"
+)
+
+// inflightWindow bounds the number of requests of a session forwarded
+// to the servers and not answered yet. Requests beyond the window wait
+// in a queue until answered requests free their slots. Close orders reduce
+// risk and can't outnumber open orders, so they're never held back: they
+// are forwarded right away taking slots even above the window, unless
+// an open of the instrument is queued. Then the close is queued behind it
+// even above the queue size, so the server doesn't get it before the open.
+type inflightWindow struct {
+	sync.Mutex
+	size      int
+	queueSize int
+	// inflight counts forwarded requests by ID, as a client may reuse IDs
+	inflight map[uint32]int
+	count    int
+	queue    []proxy.OrderRequest
+}
+
+func newInflightWindow(size, queueSize int) *inflightWindow {
+	return &inflightWindow{
+		size:      size,
+		queueSize: queueSize,
+		inflight:  make(map[uint32]int),
+	}
+}
+
+// acquire takes slots of the window for the requests. It returns requests
+// to forward right away and the ones not fitting into the full queue,
+// the rest are queued.
+func (w *inflightWindow) acquire(reqs []proxy.OrderRequest) (forward, overflow []proxy.OrderRequest) {
+	w.Lock()
+	defer w.Unlock()
+	for _, req := range reqs {
+		isClose := model.RequestType(req.ReqType) == model.RequestTypeClose
+		switch {
+		case isClose && w.queuedOpen(req.Instrument):
+			w.queue = append(w.queue, req)
+		case isClose:
+			w.take(req.ID)
+			forward = append(forward, req)
+		case w.count < w.size && len(w.queue) == 0:
+			w.take(req.ID)
+			forward = append(forward, req)
+		case len(w.queue) < w.queueSize:
+			w.queue = append(w.queue, req)
+		default:
+			overflow = append(overflow, req)
+		}
+	}
+	return forward, overflow
+}
+
+// release frees slots of the answered request IDs and returns
+// queued requests taking them
+func (w *inflightWindow) release(ids []uint32) []proxy.OrderRequest {
+	w.Lock()
+	defer w.Unlock()
+	for _, id := range ids {
+		n, ok := w.inflight[id]
+		if !ok {
+			continue
+		}
+		if n == 1 {
+			delete(w.inflight, id)
+		} else {
+			w.inflight[id] = n - 1
+		}
+		w.count--
+	}
+	var res []proxy.OrderRequest
+	for w.count < w.size && len(w.queue) > 0 {
+		req := w.queue[0]
+		w.queue = w.queue[1:]
+		w.take(req.ID)
+		res = append(res, req)
+	}
+	return res
+}
+
+// drain empties the queue and returns the requests it held
+func (w *inflightWindow) drain() []proxy.OrderRequest {
+	w.Lock()
+	defer w.Unlock()
+	res := w.queue
+	w.queue = nil
+	return res
+}
+
+// queuedOpen tells whether an open of the instrument is queued
+func (w *inflightWindow) queuedOpen(instrument string) bool {
+	for _, req := range w.queue {
+		if model.RequestType(req.ReqType) == model.RequestTypeOpen && req.Instrument == instrument {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *inflightWindow) take(id uint32) {
+	w.inflight[id]++
+	w.count++
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"time"
+
+	"test.task/backend/proxy/internal/capture"
+	"test.task/backend/proxy/internal/upstream"
+)
+
+type frameRecorder interface {
+	Record(session uint64, dir capture.Direction, mt int, data []byte)
+}
+
+// Option configures optional behaviour of the ProxyHandler
+type Option func(*ProxyHandler)
+
+// WithRecorder makes the handler capture every client and upstream frame
+func WithRecorder(rec frameRecorder) Option {
+	return func(p *ProxyHandler) {
+		p.recorder = rec
+	}
+}
+
+// WithAtomicBatches makes the handler reject a whole batch frame
+// if any of its orders is rejected
+func WithAtomicBatches() Option {
+	return func(p *ProxyHandler) {
+		p.batchAtomic = true
+	}
+}
+
+// WithUpstreamBatching makes the handler pass orders of a batch frame to
+// the order server in a single frame, if the server supports batches
+func WithUpstreamBatching() Option {
+	return func(p *ProxyHandler) {
+		p.upstreamBatch = true
+	}
+}
+
+// WithTradingService makes the handler reject orders on halted instruments
+// and out of trading sessions before passing them to the orders service
+func WithTradingService(svc tradingService) Option {
+	return func(p *ProxyHandler) {
+		p.tradingSvc = svc
+	}
+}
+
+// WithUpstreamTimeout makes the handler answer requests the server didn't
+// answer in time with a failure and release their reservations
+func WithUpstreamTimeout(timeout time.Duration) Option {
+	return func(p *ProxyHandler) {
+		p.upstreamTimeout = timeout
+	}
+}
+
+// WithRoutes makes the handler send orders on instruments matching a route
+// to the route's backends, other orders go to the default backends
+func WithRoutes(routes ...upstream.Route) Option {
+	return func(p *ProxyHandler) {
+		p.routes = routes
+	}
+}
+
+// WithBreakers makes the handler fail requests right away while
+// the circuit breaker of their route is open
+func WithBreakers(breakers *upstream.Breakers) Option {
+	return func(p *ProxyHandler) {
+		p.breakers = breakers
+	}
+}
+
+// WithInflightWindow bounds the number of requests of a client session
+// the servers haven't answered yet. Requests beyond the window wait in a queue
+// of queueSize requests until answers free the window, the rest are rejected.
+func WithInflightWindow(size, queueSize int) Option {
+	return func(p *ProxyHandler) {
+		p.inflightWindow = size
+		p.inflightQueue = queueSize
+	}
+}
+
+// WithKillSwitch makes the handler refuse and disconnect clients
+// blocked entirely by the kill switch
+func WithKillSwitch(ks killSwitch) Option {
+	return func(p *ProxyHandler) {
+		p.killSwitch = ks
+	}
+}
+
+// WithUpgrader configures WebSocket upgrades of clients
+func WithUpgrader(cfg UpgraderConfig) Option {
+	return func(p *ProxyHandler) {
+		if len(cfg.AllowedOrigins) > 0 {
+			p.upgrader.CheckOrigin = originChecker(cfg.AllowedOrigins)
+		}
+		p.upgrader.ReadBufferSize = cfg.ReadBufferSize
+		p.upgrader.WriteBufferSize = cfg.WriteBufferSize
+		p.upgrader.EnableCompression = cfg.EnableCompression
+		p.requiredHeaders = cfg.RequiredHeaders
+		p.maxMessageSize = cfg.MaxMessageSize
+		p.compressionLevel = cfg.CompressionLevel
+	}
+}
+
+// WithBackends makes the handler pick the order server for a client
+// from the pool instead of the single backend address
+func WithBackends(pool backendPool) Option {
+	return func(p *ProxyHandler) {
+		p.backends = pool
+	}
+}
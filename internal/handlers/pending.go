@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"sync"
+	"time"
+)
+
+// pendingRequest is a request forwarded to the server waiting for the answer
+type pendingRequest struct {
+	timer *time.Timer
+	// gen is the generation of the route's breaker the request was let through in
+	gen uint64
+}
+
+// pendingRequests tracks requests forwarded to the server
+// until they're answered or time out
+type pendingRequests struct {
+	sync.Mutex
+	timeout time.Duration
+	// requests holds pending requests by ID oldest first,
+	// as a client may reuse IDs
+	requests map[uint32][]*pendingRequest
+}
+
+func newPendingRequests(timeout time.Duration) *pendingRequests {
+	return &pendingRequests{
+		timeout:  timeout,
+		requests: make(map[uint32][]*pendingRequest),
+	}
+}
+
+// add starts tracking the request ID, onTimeout is called once the request
+// isn't answered in time. Requests reusing a pending ID are answered
+// in the order they were added.
+func (pr *pendingRequests) add(id uint32, gen uint64, onTimeout func()) {
+	pr.Lock()
+	defer pr.Unlock()
+	req := &pendingRequest{gen: gen}
+	req.timer = time.AfterFunc(pr.timeout, func() {
+		if pr.remove(id, req) {
+			onTimeout()
+		}
+	})
+	pr.requests[id] = append(pr.requests[id], req)
+}
+
+// resolve stops tracking the oldest request with the answered ID and returns
+// its breaker generation, it returns false if no request is pending,
+// because it has timed out or is unknown
+func (pr *pendingRequests) resolve(id uint32) (uint64, bool) {
+	pr.Lock()
+	defer pr.Unlock()
+	reqs := pr.requests[id]
+	if len(reqs) == 0 {
+		return 0, false
+	}
+	req := reqs[0]
+	req.timer.Stop()
+	pr.drop(id, 0)
+	return req.gen, true
+}
+
+// remove stops tracking the request and tells whether it was pending
+func (pr *pendingRequests) remove(id uint32, req *pendingRequest) bool {
+	pr.Lock()
+	defer pr.Unlock()
+	for i, r := range pr.requests[id] {
+		if r == req {
+			pr.drop(id, i)
+			return true
+		}
+	}
+	return false
+}
+
+// stop stops tracking all requests without calling their onTimeout,
+// once the session is over
+func (pr *pendingRequests) stop() {
+	pr.Lock()
+	defer pr.Unlock()
+	for id, reqs := range pr.requests {
+		for _, req := range reqs {
+			req.timer.Stop()
+		}
+		delete(pr.requests, id)
+	}
+}
+
+// drop removes the i-th request with the ID, must be called under the lock
+func (pr *pendingRequests) drop(id uint32, i int) {
+	reqs := pr.requests[id]
+	if len(reqs) == 1 {
+		delete(pr.requests, id)
+		return
+	}
+	pr.requests[id] = append(reqs[:i:i], reqs[i+1:]...)
+}
@@ -4,10 +4,15 @@ import (
 	"log"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gorilla/websocket"
 	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/capture"
+	"test.task/backend/proxy/internal/metrics"
 	"test.task/backend/proxy/internal/model"
+	"test.task/backend/proxy/internal/upstream"
 )
 
 type orderAdapter interface {
@@ -17,6 +22,22 @@ type orderAdapter interface {
 
 type ordersService interface {
 	ProcessOrder(order model.OrderRequest) error
+	ProcessBatch(orders []model.OrderRequest, atomic bool) []error
+	Rollback(order model.OrderRequest)
+	// ClosingOrders returns close orders flattening open positions of the client
+	ClosingOrders(clientID uint32) []model.OrderRequest
+	// ForceClose applies a close the server accepted on behalf of the client
+	ForceClose(order model.OrderRequest)
+}
+
+type tradingService interface {
+	CheckOrder(order model.OrderRequest) error
+}
+
+type backendPool interface {
+	// Candidates returns backend addresses to try for the client in order
+	Candidates(clientID uint32) []string
+	MarkDown(addr string)
 }
 
 type clientsService interface {
@@ -24,15 +45,46 @@ type clientsService interface {
 	DisconnectClient(clientID uint32)
 }
 
+type killSwitch interface {
+	// Kicked returns a channel closed once the client is blocked entirely
+	// and a func releasing it once the session is over
+	Kicked(clientID uint32) (<-chan struct{}, func())
+}
+
 type ProxyHandler struct {
 	sync.Mutex
-	backendAddr      string
+	backends         backendPool
+	routes           []upstream.Route
+	router           *upstream.Router
+	breakers         *upstream.Breakers
 	adapter          orderAdapter
 	ordersSvc        ordersService
 	clientsSvc       clientsService
 	connectedClients map[uint32]struct{}
 	upgrader         websocket.Upgrader
+	// requiredHeaders must be present in upgrade requests
+	requiredHeaders map[string]string
+	// maxMessageSize is the limit of a client frame, unlimited if zero
+	maxMessageSize int64
+	// compressionLevel is applied to client connections if compression is enabled
+	compressionLevel int
 	dialer           *websocket.Dialer
+	recorder         frameRecorder
+	tradingSvc       tradingService
+	killSwitch       killSwitch
+	lastSessionID    uint64
+	// batchAtomic rejects the whole batch frame if any of its orders is rejected
+	batchAtomic bool
+	// upstreamBatch sends orders of a batch frame to the server in a single frame
+	upstreamBatch bool
+	// upstreamTimeout is the time the server has to answer a request,
+	// zero waits forever
+	upstreamTimeout time.Duration
+	// inflightWindow is the number of requests of a session the servers
+	// haven't answered yet, requests beyond it wait in a queue of
+	// inflightQueue ones or are rejected. Zero is unbounded.
+	inflightWindow int
+	inflightQueue  int
 }
 
 func NewProxyHandler(
@@ -40,116 +92,491 @@ func NewProxyHandler(
 	adapter orderAdapter,
 	ordersSvc ordersService,
 	clientsSvc clientsService,
+	opts ...Option,
 ) *ProxyHandler {
-	return &ProxyHandler{
-		backendAddr:      backendAddr,
+	p := &ProxyHandler{
+		backends:         singleBackend(backendAddr),
 		adapter:          adapter,
 		ordersSvc:        ordersSvc,
 		clientsSvc:       clientsSvc,
 		connectedClients: make(map[uint32]struct{}),
-		upgrader:         websocket.Upgrader{},
-		dialer:           websocket.DefaultDialer,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{
+				proxy.SubprotocolBinary,
+				proxy.SubprotocolJSON,
+				proxy.SubprotocolBatch,
+			},
+		},
+		dialer: websocket.DefaultDialer,
+	}
+	for _, opt := range opts {
+		opt(p)
 	}
+	p.router = upstream.NewRouter(p.backends, p.routes...)
+	return p
+}
+
+// Upgrader returns the upgrader of client connections, so connections
+// rejected before the handler are upgraded the same way
+func (p *ProxyHandler) Upgrader() *websocket.Upgrader {
+	return &p.upgrader
 }
 
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := p.checkHeaders(r); err != nil {
+		log.Print("upgrade client request:", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
 	clientWS, err := p.upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Print("upgrade client request:", err)
 		return
 	}
+	if p.maxMessageSize > 0 {
+		clientWS.SetReadLimit(p.maxMessageSize)
+	}
+	if p.upgrader.EnableCompression && p.compressionLevel != 0 {
+		if err := clientWS.SetCompressionLevel(p.compressionLevel); err != nil {
+			log.Print("set compression level:", err)
+		}
+	}
+
+	s := newSession(atomic.AddUint64(&p.lastSessionID, 1), clientWS)
+	if p.upstreamTimeout > 0 {
+		s.pending = newPendingRequests(p.upstreamTimeout)
+	}
+	if p.inflightWindow > 0 {
+		s.window = newInflightWindow(p.inflightWindow, p.inflightQueue)
+	}
+	p.record(s, capture.DirectionOpen, 0, []byte(clientWS.Subprotocol()))
 
 	// reading message first time not in a loop because firstly
 	// we need to get client id which is inside binary message
-	mt, message, err := clientWS.ReadMessage()
+	message, err := p.readFromClient(s)
 	if err != nil {
 		return
 	}
-	req := proxy.DecodeOrderRequest(message)
-	clientID := req.ClientID
+	// clients of protocol version 2 and above start with a hello frame,
+	// legacy clients send an order right away
+	if proxy.IsHello(message) {
+		hello, _ := proxy.DecodeHello(message)
+		if err := p.handshake(s, hello); err != nil {
+			log.Printf("handshake: %v", err)
+			closeConn(clientWS, websocket.CloseProtocolError, err.Error())
+			clientWS.Close()
+			return
+		}
+		if message, err = p.readFromClient(s); err != nil {
+			return
+		}
+	}
+	reqs, err := s.codec.decodeRequests(message)
+	if err != nil || len(reqs) == 0 {
+		log.Printf("decode first message: %v", err)
+		clientWS.Close()
+		return
+	}
+	s.clientID = reqs[0].ClientID
 
 	// checking initial connection
-	filterPassed := p.filterConnection(clientWS, clientID)
+	filterPassed := p.filterConnection(clientWS, s.clientID)
 	if !filterPassed {
 		return
 	}
 
-	serverWS := p.mustGetServerConn()
-	// firstProcess runs once when connection had been established
-	p.firstProcess(clientWS, serverWS, req, mt, message)
+	// the default route is connected right away, other routes
+	// are connected on the first order routed to them
+	if _, err := p.routeConn(s, "", p.backends); err != nil {
+		closeConn(clientWS, websocket.CloseTryAgainLater, "order server unavailable")
+		clientWS.Close()
+		p.clientsSvc.DisconnectClient(s.clientID)
+		return
+	}
+	// the first frame is processed once connection had been established
+	p.processRequests(s, reqs)
 
-	// start listening from server and repeat message directly to client
-	go p.serverToClient(serverWS, clientWS)
+	if p.killSwitch != nil {
+		kicked, release := p.killSwitch.Kicked(s.clientID)
+		go p.kickOnBlock(s, kicked, release)
+	}
 	// process client message and pass it to server if everything is ok
-	p.clientToServer(clientWS, serverWS, clientID)
+	p.clientToServer(s)
 }
 
-func (p *ProxyHandler) clientToServer(clientWS, serverWS *websocket.Conn, clientID uint32) {
-	defer clientWS.Close()
+// kickOnBlock disconnects the client once the kill switch blocks it entirely
+func (p *ProxyHandler) kickOnBlock(s *session, kicked <-chan struct{}, release func()) {
+	defer release()
+	select {
+	case <-kicked:
+		log.Printf("client %d is blocked, disconnecting", s.clientID)
+		s.clientMu.Lock()
+		closeConn(s.clientWS, websocket.ClosePolicyViolation, "client is blocked")
+		s.clientMu.Unlock()
+		s.clientWS.Close()
+	case <-s.done:
+	}
+}
+
+func (p *ProxyHandler) clientToServer(s *session) {
+	defer close(s.done)
+	defer s.clientWS.Close()
+	defer p.dropQueued(s)
+	defer s.closeServer()
+	// requests in flight may have been executed by the server,
+	// so they're neither answered nor rolled back on timeout
+	if s.pending != nil {
+		defer s.pending.stop()
+	}
 	for {
-		mt, message, err := clientWS.ReadMessage()
+		message, err := p.readFromClient(s)
 		if err != nil {
-			p.clientsSvc.DisconnectClient(clientID)
+			p.clientsSvc.DisconnectClient(s.clientID)
 			break
 		}
-		req := proxy.DecodeOrderRequest(message)
-		log.Printf("recv from client: %v", req)
-		id := req.ID
+		reqs, err := s.codec.decodeRequests(message)
+		if err != nil {
+			// there is no request ID to answer to
+			log.Printf("decode client message: %v", err)
+			continue
+		}
+		p.processRequests(s, reqs)
+	}
+}
 
-		translatedOrder, err := p.adapter.TranslateOrder(req)
+// serverToClient relays responses of a server to the client, responses
+// of all servers the session is connected to are merged onto the client socket
+func (p *ProxyHandler) serverToClient(s *session, conn *upstreamConn) {
+	for {
+		mt, messsage, err := conn.ws.ReadMessage()
 		if err != nil {
-			p.writeErrorToClient(clientWS, id, err)
+			log.Printf("recv error: %+v", err)
+			if !s.serverClosed() {
+				conn.backends.MarkDown(conn.addr)
+			}
+			p.upstreamLost(s, conn)
+			return
+		}
+		p.record(s, capture.DirectionUpstream, mt, messsage)
+		res, err := decodeServerResponses(conn.ws, messsage)
+		if err != nil {
+			log.Printf("decode server message: %v", err)
 			continue
 		}
-		if err := p.ordersSvc.ProcessOrder(translatedOrder); err != nil {
-			p.writeErrorToClient(clientWS, id, err)
+		conn.untrack(responseIDs(res))
+		if res = p.resolvePending(s, conn.route, res); len(res) == 0 {
 			continue
 		}
 
-		if err = writeToConn(serverWS, "server", mt, message); err != nil {
+		err = p.writeResponsesToClient(s, res)
+		p.forward(s, p.releaseWindow(s, responseIDs(res)))
+		if err != nil {
 			continue
 		}
 
-		log.Printf("sent to server: %v", req)
+		log.Printf("recv from server and sent to client: %v", res)
+	}
+}
+
+// upstreamLost handles a lost server connection. Losing the default route's
+// server ends the session. Requests pending at a server of another route
+// are failed with ErrUpstreamUnavailable and the route is connected again
+// on its next request, so the session keeps trading on healthy routes.
+func (p *ProxyHandler) upstreamLost(s *session, conn *upstreamConn) {
+	if conn.route == "" {
+		s.clientWS.Close()
+		return
+	}
+	s.upstreamsMu.Lock()
+	if s.upstreams[conn.route] == conn {
+		delete(s.upstreams, conn.route)
 	}
+	s.upstreamsMu.Unlock()
+	conn.ws.Close()
+	reqs := conn.lose()
+	if s.serverClosed() {
+		return
+	}
+	p.upstreamFailed(conn.route, p.upstreamGeneration(conn.route))
+	failed := p.failRequests(s, reqs, model.ErrUpstreamUnavailable)
+	p.forward(s, p.releaseWindow(s, requestIDs(failed)))
 }
 
-func (p *ProxyHandler) serverToClient(serverWS, clientWS *websocket.Conn) {
-	defer clientWS.Close()
-	for {
-		mt, messsage, err := serverWS.ReadMessage()
+// processRequests filters requests of a client frame and passes
+// the accepted ones to the server
+func (p *ProxyHandler) processRequests(s *session, reqs []proxy.OrderRequest) {
+	for _, req := range reqs {
+		log.Printf("recv from client: %v", req)
+	}
+	accepted, rejected := p.filterRequests(reqs)
+	p.writeErrorsToClient(s, rejected)
+	if s.window != nil {
+		var overflow []proxy.OrderRequest
+		accepted, overflow = s.window.acquire(accepted)
+		p.rejectAccepted(s, overflow, model.ErrTooManyInflight)
+	}
+	p.forward(s, accepted)
+}
+
+// forward passes requests to the servers, requests failed on the way
+// free their slots of the in-flight window for queued ones
+func (p *ProxyHandler) forward(s *session, reqs []proxy.OrderRequest) {
+	for len(reqs) > 0 {
+		failed := p.failRequests(s, p.writeRequestsToServer(s, reqs), model.ErrUpstreamUnavailable)
+		reqs = p.releaseWindow(s, requestIDs(failed))
+	}
+}
+
+// releaseWindow frees slots of the answered requests in the in-flight
+// window and returns queued requests to forward
+func (p *ProxyHandler) releaseWindow(s *session, ids []uint32) []proxy.OrderRequest {
+	if s.window == nil || len(ids) == 0 {
+		return nil
+	}
+	return s.window.release(ids)
+}
+
+// dropQueued releases reservations of requests still queued
+// once the client is gone
+func (p *ProxyHandler) dropQueued(s *session) {
+	if s.window == nil {
+		return
+	}
+	for _, req := range s.window.drain() {
+		if order, err := p.adapter.TranslateOrder(req); err == nil {
+			p.ordersSvc.Rollback(order)
+		}
+	}
+}
+
+// trackPending starts the deadline of a request about to be forwarded
+// to the server, let through by the route's breaker in the generation
+func (p *ProxyHandler) trackPending(s *session, req proxy.OrderRequest, route string, gen uint64) {
+	if s.pending == nil {
+		return
+	}
+	s.pending.add(req.ID, gen, func() { p.expire(s, req, route, gen) })
+}
+
+// expire answers the request the server didn't answer in time
+// and releases its reservation in the orders service. Requests of
+// a finished session are left alone, the proxy closed the server
+// connections itself and there's nobody to answer.
+func (p *ProxyHandler) expire(s *session, req proxy.OrderRequest, route string, gen uint64) {
+	if s.serverClosed() {
+		return
+	}
+	p.upstreamFailed(route, gen)
+	if order, err := p.adapter.TranslateOrder(req); err == nil {
+		p.ordersSvc.Rollback(order)
+	}
+	p.writeErrorsToClient(s, []rejection{{id: req.ID, err: model.ErrUpstreamTimeout}})
+	p.forward(s, p.releaseWindow(s, []uint32{req.ID}))
+}
+
+// allowUpstream tells whether the route's breaker lets a request through
+// and returns the breaker generation its outcome is recorded with
+func (p *ProxyHandler) allowUpstream(route string) (uint64, bool) {
+	if p.breakers == nil {
+		return 0, true
+	}
+	gen, ok := p.breakers.Get(route).Allow()
+	if !ok {
+		if route == "" {
+			route = upstream.DefaultRouteName
+		}
+		metrics.BreakerRejections.Add(route, 1)
+	}
+	return gen, ok
+}
+
+// upstreamGeneration returns the current generation of the route's breaker
+func (p *ProxyHandler) upstreamGeneration(route string) uint64 {
+	if p.breakers == nil {
+		return 0
+	}
+	return p.breakers.Get(route).Generation()
+}
+
+// upstreamSucceeded records a request of the breaker generation
+// answered by a server of the route
+func (p *ProxyHandler) upstreamSucceeded(route string, gen uint64) {
+	if p.breakers == nil {
+		return
+	}
+	p.breakers.Get(route).Success(gen)
+}
+
+// upstreamFailed records a request of the breaker generation
+// failed by the route's servers
+func (p *ProxyHandler) upstreamFailed(route string, gen uint64) {
+	if p.breakers == nil {
+		return
+	}
+	p.breakers.Get(route).Failure(gen)
+}
+
+// failRequests answers pending requests that couldn't be passed
+// to a server or were lost with it with the error, releases their
+// reservations and returns them
+func (p *ProxyHandler) failRequests(s *session, reqs []proxy.OrderRequest, err error) []proxy.OrderRequest {
+	if s.pending == nil {
+		p.rejectAccepted(s, reqs, err)
+		return reqs
+	}
+	failed := make([]proxy.OrderRequest, 0, len(reqs))
+	for _, req := range reqs {
+		// skipped if already answered on timeout
+		if _, ok := s.pending.resolve(req.ID); ok {
+			failed = append(failed, req)
+		}
+	}
+	p.rejectAccepted(s, failed, err)
+	return failed
+}
+
+// rejectAccepted answers requests accepted by the orders service
+// with the error and releases their reservations
+func (p *ProxyHandler) rejectAccepted(s *session, reqs []proxy.OrderRequest, err error) {
+	if len(reqs) == 0 {
+		return
+	}
+	rejected := make([]rejection, 0, len(reqs))
+	for _, req := range reqs {
+		if order, err := p.adapter.TranslateOrder(req); err == nil {
+			p.ordersSvc.Rollback(order)
+		}
+		rejected = append(rejected, rejection{id: req.ID, err: err})
+	}
+	p.writeErrorsToClient(s, rejected)
+}
+
+func requestIDs(reqs []proxy.OrderRequest) []uint32 {
+	res := make([]uint32, len(reqs))
+	for i, req := range reqs {
+		res[i] = req.ID
+	}
+	return res
+}
+
+func responseIDs(res []proxy.OrderResponse) []uint32 {
+	ids := make([]uint32, len(res))
+	for i, r := range res {
+		ids[i] = r.ID
+	}
+	return ids
+}
+
+// resolvePending returns responses to pending requests and records them
+// in the route's breaker unless the session is over, late responses
+// to timed out requests are dropped
+func (p *ProxyHandler) resolvePending(s *session, route string, res []proxy.OrderResponse) []proxy.OrderResponse {
+	record := !s.serverClosed()
+	if s.pending == nil {
+		// requests aren't tracked, so they're taken for the current generation
+		if record {
+			gen := p.upstreamGeneration(route)
+			for range res {
+				p.upstreamSucceeded(route, gen)
+			}
+		}
+		return res
+	}
+	answered := res[:0]
+	for _, r := range res {
+		gen, ok := s.pending.resolve(r.ID)
+		if !ok {
+			log.Printf("late response from server dropped: %v", r)
+			continue
+		}
+		if record {
+			p.upstreamSucceeded(route, gen)
+		}
+		answered = append(answered, r)
+	}
+	return answered
+}
+
+// rejection is a request rejected by the proxy itself
+type rejection struct {
+	id  uint32
+	err error
+}
+
+func (p *ProxyHandler) filterRequests(reqs []proxy.OrderRequest) ([]proxy.OrderRequest, []rejection) {
+	if len(reqs) == 1 {
+		req := reqs[0]
+		translatedOrder, err := p.adapter.TranslateOrder(req)
+		if err == nil {
+			err = p.checkTrading(translatedOrder)
+		}
+		if err == nil {
+			err = p.ordersSvc.ProcessOrder(translatedOrder)
+		}
 		if err != nil {
-			log.Printf("recv error: %+v", err)
-			return
+			// the task description didn't specify the way to respond to invalid
+			// requests, so I've decided to send back "Other" result code
+			return nil, []rejection{{id: req.ID, err: err}}
 		}
-		res := proxy.DecodeOrderResponse(messsage)
+		return reqs, nil
+	}
 
-		if err = writeToConn(clientWS, "client", mt, messsage); err != nil {
+	var rejected []rejection
+	valid := make([]proxy.OrderRequest, 0, len(reqs))
+	orders := make([]model.OrderRequest, 0, len(reqs))
+	for _, req := range reqs {
+		translatedOrder, err := p.adapter.TranslateOrder(req)
+		if err == nil {
+			err = p.checkTrading(translatedOrder)
+		}
+		if err != nil {
+			rejected = append(rejected, rejection{id: req.ID, err: err})
 			continue
 		}
+		valid = append(valid, req)
+		orders = append(orders, translatedOrder)
+	}
+	if p.batchAtomic && len(rejected) > 0 {
+		for _, req := range valid {
+			rejected = append(rejected, rejection{id: req.ID, err: model.ErrBatchRejected})
+		}
+		return nil, rejected
+	}
 
-		log.Printf("recv from server and sent to client: %v", res)
+	accepted := make([]proxy.OrderRequest, 0, len(valid))
+	for i, err := range p.ordersSvc.ProcessBatch(orders, p.batchAtomic) {
+		if err != nil {
+			rejected = append(rejected, rejection{id: valid[i].ID, err: err})
+			continue
+		}
+		accepted = append(accepted, valid[i])
 	}
+	return accepted, rejected
 }
 
-func (p *ProxyHandler) firstProcess(
-	clientWS, serverWS *websocket.Conn,
-	req proxy.OrderRequest,
-	mt int,
-	message []byte,
-) {
-	id := req.ID
-	translatedOrder, err := p.adapter.TranslateOrder(req)
+// checkTrading tells whether the order's instrument can be traded at the moment
+func (p *ProxyHandler) checkTrading(order model.OrderRequest) error {
+	if p.tradingSvc == nil {
+		return nil
+	}
+	return p.tradingSvc.CheckOrder(order)
+}
+
+// readFromClient reads the next frame from the client
+func (p *ProxyHandler) readFromClient(s *session) ([]byte, error) {
+	mt, message, err := s.clientWS.ReadMessage()
 	if err != nil {
-		// the task description didn't specify the way to respond to invalid
-		// requests, so I've decided to send back "Other" result code
-		p.writeErrorToClient(clientWS, id, err)
-		return
+		return nil, err
 	}
-	if err = p.ordersSvc.ProcessOrder(translatedOrder); err != nil {
-		p.writeErrorToClient(clientWS, id, err)
+	p.record(s, capture.DirectionClient, mt, message)
+	s.setClientFrameType(mt)
+	return message, nil
+}
+
+// record passes the frame to the recorder if capturing is enabled
+func (p *ProxyHandler) record(s *session, dir capture.Direction, mt int, message []byte) {
+	if p.recorder == nil {
 		return
 	}
-	// first-time write to server after establishing connection with client
-	writeToConn(serverWS, "server", mt, message)
+	p.recorder.Record(s.id, dir, mt, message)
 }
@@ -4,12 +4,18 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 	proxy "test.task/backend/proxy"
 	"test.task/backend/proxy/internal/adapter"
+	"test.task/backend/proxy/internal/capture"
+	"test.task/backend/proxy/internal/mockserver"
+	"test.task/backend/proxy/internal/model"
 	"test.task/backend/proxy/internal/service"
+	"test.task/backend/proxy/internal/upstream"
 )
 
 func TestProxyHandler(t *testing.T) {
@@ -71,8 +77,11 @@ func TestProxyHandler(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
+			backend := mockserver.NewTestServer(mockserver.Scenario{})
+			defer backend.Close()
+
 			handler := NewProxyHandler(
-				"localhost:8081",
+				backendHost(t, backend),
 				adapter.NewOrderAdapter(),
 				tc.ordersService,
 				service.NewClientsService(),
@@ -93,6 +102,1316 @@ func TestProxyHandler(t *testing.T) {
 	}
 }
 
+type recorderMock struct {
+	sync.Mutex
+	frames []capture.Frame
+}
+
+func (r *recorderMock) Record(session uint64, dir capture.Direction, mt int, data []byte) {
+	r.Lock()
+	defer r.Unlock()
+	r.frames = append(r.frames, capture.Frame{
+		Session:     session,
+		Direction:   dir,
+		MessageType: mt,
+		Data:        data,
+	})
+}
+
+func (r *recorderMock) directions() []capture.Direction {
+	r.Lock()
+	defer r.Unlock()
+	var dirs []capture.Direction
+	for _, frame := range r.frames {
+		dirs = append(dirs, frame.Direction)
+	}
+	return dirs
+}
+
+func TestProxyHandlerCapture(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	rec := &recorderMock{}
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(1, 3000),
+		service.NewClientsService(),
+		WithRecorder(rec),
+	)
+
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+
+	req := proxy.OrderRequest{
+		ClientID:   4815,
+		ID:         1,
+		ReqType:    1,
+		OrderKind:  1,
+		Volume:     1000,
+		Instrument: "USDEUR",
+	}
+	sendMessage(t, ws, req)
+	receiveWSMessage(t, ws)
+
+	// second order exceeds the limit and is rejected by the proxy
+	req.ID = 2
+	sendMessage(t, ws, req)
+	receiveWSMessage(t, ws)
+
+	want := []capture.Direction{
+		capture.DirectionOpen,
+		capture.DirectionClient,
+		capture.DirectionUpstream,
+		capture.DirectionClient,
+		capture.DirectionProxy,
+	}
+	got := rec.directions()
+	if len(got) != len(want) {
+		t.Fatalf("Expected frames %v, got %v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected frames %v, got %v", want, got)
+		}
+	}
+}
+
+func TestProxyHandlerFrameType(t *testing.T) {
+	cases := []struct {
+		name      string
+		frameType int
+		volume    float64
+		code      uint16
+	}{
+		{
+			name:      "binary frame relayed from server",
+			frameType: websocket.BinaryMessage,
+			volume:    100,
+			code:      0,
+		},
+		{
+			name:      "text frame relayed from server",
+			frameType: websocket.TextMessage,
+			volume:    100,
+			code:      0,
+		},
+		{
+			name:      "binary frame rejected by proxy",
+			frameType: websocket.BinaryMessage,
+			volume:    5000,
+			code:      2,
+		},
+		{
+			name:      "text frame rejected by proxy",
+			frameType: websocket.TextMessage,
+			volume:    5000,
+			code:      2,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := mockserver.NewTestServer(mockserver.Scenario{})
+			defer backend.Close()
+
+			rec := &recorderMock{}
+			handler := NewProxyHandler(
+				backendHost(t, backend),
+				adapter.NewOrderAdapter(),
+				service.NewOrdersService(4, 3000),
+				service.NewClientsService(),
+				WithRecorder(rec),
+			)
+
+			s, ws := newWSServer(t, handler)
+			defer s.Close()
+			defer ws.Close()
+
+			req := proxy.OrderRequest{
+				ClientID:   4815,
+				ID:         162342,
+				ReqType:    1,
+				OrderKind:  1,
+				Volume:     tc.volume,
+				Instrument: "USDEUR",
+			}
+			if err := ws.WriteMessage(tc.frameType, proxy.EncodeOrderRequest(req)); err != nil {
+				t.Fatal(err)
+			}
+
+			mt, m, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mt != tc.frameType {
+				t.Fatalf("Expected frame type %d, got %d", tc.frameType, mt)
+			}
+			if got := proxy.DecodeOrderResponse(m); got.Code != tc.code {
+				t.Fatalf("Expected code %d, got %d", tc.code, got.Code)
+			}
+			// the order server always gets binary frames and answers the same way
+			rec.Lock()
+			defer rec.Unlock()
+			for _, frame := range rec.frames {
+				if frame.Direction == capture.DirectionUpstream && frame.MessageType != websocket.BinaryMessage {
+					t.Fatalf("Expected binary frames from server, got %d", frame.MessageType)
+				}
+			}
+		})
+	}
+}
+
+func TestProxyHandlerJSON(t *testing.T) {
+	cases := []struct {
+		name     string
+		request  string
+		response string
+	}{
+		{
+			name:     "open order relayed from server",
+			request:  `{"clientId":4815,"id":1,"reqType":1,"orderKind":1,"volume":100,"instrument":"USDEUR"}`,
+			response: `{"id":1,"code":0}`,
+		},
+		{
+			name:     "open order rejected by proxy",
+			request:  `{"clientId":4815,"id":2,"reqType":1,"orderKind":1,"volume":5000,"instrument":"USDEUR"}`,
+			response: `{"id":2,"code":2}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := mockserver.NewTestServer(mockserver.Scenario{})
+			defer backend.Close()
+
+			handler := NewProxyHandler(
+				backendHost(t, backend),
+				adapter.NewOrderAdapter(),
+				service.NewOrdersService(4, 3000),
+				service.NewClientsService(),
+			)
+			s := httptest.NewServer(handler)
+			defer s.Close()
+
+			dialer := websocket.Dialer{Subprotocols: []string{proxy.SubprotocolJSON}}
+			ws, _, err := dialer.Dial(httpToWS(t, s.URL), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+			if ws.Subprotocol() != proxy.SubprotocolJSON {
+				t.Fatalf("Expected subprotocol %s, got %q", proxy.SubprotocolJSON, ws.Subprotocol())
+			}
+
+			if err := ws.WriteMessage(websocket.TextMessage, []byte(tc.request)); err != nil {
+				t.Fatal(err)
+			}
+			mt, m, err := ws.ReadMessage()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mt != websocket.TextMessage {
+				t.Fatalf("Expected text frame, got %d", mt)
+			}
+			if string(m) != tc.response {
+				t.Fatalf("Expected %s, got %s", tc.response, m)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerBatch(t *testing.T) {
+	order := func(id uint32, volume float64) proxy.OrderRequest {
+		return proxy.OrderRequest{
+			ClientID:   4815,
+			ID:         id,
+			ReqType:    1,
+			OrderKind:  1,
+			Volume:     volume,
+			Instrument: "USDEUR",
+		}
+	}
+
+	cases := []struct {
+		name string
+		opts []Option
+		// upstreamFrames is the number of frames the order server gets
+		upstreamFrames int
+		want           map[uint32]uint16
+	}{
+		{
+			name:           "individual evaluation unbatched upstream",
+			upstreamFrames: 2,
+			want:           map[uint32]uint16{1: 0, 2: 2, 3: 0},
+		},
+		{
+			name:           "individual evaluation rebatched upstream",
+			opts:           []Option{WithUpstreamBatching()},
+			upstreamFrames: 1,
+			want:           map[uint32]uint16{1: 0, 2: 2, 3: 0},
+		},
+		{
+			name:           "atomic evaluation",
+			opts:           []Option{WithAtomicBatches(), WithUpstreamBatching()},
+			upstreamFrames: 0,
+			want:           map[uint32]uint16{1: 3, 2: 2, 3: 3},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := mockserver.NewTestServer(mockserver.Scenario{})
+			defer backend.Close()
+
+			rec := &recorderMock{}
+			handler := NewProxyHandler(
+				backendHost(t, backend),
+				adapter.NewOrderAdapter(),
+				service.NewOrdersService(4, 3000),
+				service.NewClientsService(),
+				append(tc.opts, WithRecorder(rec))...,
+			)
+			s := httptest.NewServer(handler)
+			defer s.Close()
+
+			dialer := websocket.Dialer{Subprotocols: []string{proxy.SubprotocolBatch}}
+			ws, _, err := dialer.Dial(httpToWS(t, s.URL), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer ws.Close()
+
+			batch, err := proxy.EncodeOrderRequestBatch([]proxy.OrderRequest{
+				order(1, 1000),
+				order(2, 5000),
+				order(3, 1000),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := ws.WriteMessage(websocket.BinaryMessage, batch); err != nil {
+				t.Fatal(err)
+			}
+
+			got := make(map[uint32]uint16)
+			for len(got) < len(tc.want) {
+				_, m, err := ws.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				res, err := proxy.DecodeOrderResponseBatch(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, r := range res {
+					got[r.ID] = r.Code
+				}
+			}
+			for id, code := range tc.want {
+				if got[id] != code {
+					t.Fatalf("Expected %v, got %v", tc.want, got)
+				}
+			}
+
+			rec.Lock()
+			defer rec.Unlock()
+			upstreamFrames := 0
+			for _, frame := range rec.frames {
+				if frame.Direction == capture.DirectionUpstream {
+					upstreamFrames++
+				}
+			}
+			if upstreamFrames != tc.upstreamFrames {
+				t.Fatalf("Expected %d frames from server, got %d", tc.upstreamFrames, upstreamFrames)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerHandshake(t *testing.T) {
+	invalidOrder := proxy.OrderRequest{
+		ClientID:   4815,
+		ID:         1,
+		ReqType:    9,
+		OrderKind:  1,
+		Volume:     100,
+		Instrument: "USDEUR",
+	}
+
+	cases := []struct {
+		name    string
+		hello   *proxy.Hello
+		wantAck proxy.Hello
+		// wantCode is the code of the invalid order
+		wantCode uint16
+	}{
+		{
+			name:     "legacy client gets legacy codes",
+			wantCode: 3,
+		},
+		{
+			name:     "version 1 client gets legacy codes",
+			hello:    &proxy.Hello{Version: proxy.ProtocolV1},
+			wantAck:  proxy.Hello{Version: proxy.ProtocolV1},
+			wantCode: 3,
+		},
+		{
+			name:     "version 2 client gets extended codes",
+			hello:    &proxy.Hello{Version: proxy.ProtocolV2},
+			wantAck:  proxy.Hello{Version: proxy.ProtocolV2},
+			wantCode: 4,
+		},
+		{
+			name:     "newer client is downgraded",
+			hello:    &proxy.Hello{Version: 42, Capabilities: 1 << 20},
+			wantAck:  proxy.Hello{Version: proxy.CurrentVersion},
+			wantCode: 4,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			backend := mockserver.NewTestServer(mockserver.Scenario{})
+			defer backend.Close()
+
+			handler := NewProxyHandler(
+				backendHost(t, backend),
+				adapter.NewOrderAdapter(),
+				service.NewOrdersService(4, 3000),
+				service.NewClientsService(),
+			)
+			s, ws := newWSServer(t, handler)
+			defer s.Close()
+			defer ws.Close()
+
+			if tc.hello != nil {
+				if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeHello(*tc.hello)); err != nil {
+					t.Fatal(err)
+				}
+				_, m, err := ws.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				ack, err := proxy.DecodeHello(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if ack != tc.wantAck {
+					t.Fatalf("Expected ack %+v, got %+v", tc.wantAck, ack)
+				}
+			}
+
+			sendMessage(t, ws, invalidOrder)
+			got := receiveWSMessage(t, ws)
+			if got.Code != tc.wantCode {
+				t.Fatalf("Expected code %d, got %d", tc.wantCode, got.Code)
+			}
+		})
+	}
+}
+
+func TestProxyHandlerHandshakeCapabilities(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+
+	hello := proxy.Hello{
+		Version:      proxy.ProtocolV2,
+		Capabilities: proxy.CapabilityBatch | proxy.CapabilityJSON,
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeHello(hello)); err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := proxy.DecodeHello(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ack.Capabilities != proxy.CapabilityBatch {
+		t.Fatalf("Expected only batch capability accepted, got %+v", ack)
+	}
+
+	batch, err := proxy.EncodeOrderRequestBatch([]proxy.OrderRequest{{
+		ClientID:   4815,
+		ID:         1,
+		ReqType:    1,
+		OrderKind:  1,
+		Volume:     100,
+		Instrument: "USDEUR",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, batch); err != nil {
+		t.Fatal(err)
+	}
+	if _, m, err = ws.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	res, err := proxy.DecodeOrderResponseBatch(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != 1 || res[0] != (proxy.OrderResponse{ID: 1, Code: 0}) {
+		t.Fatalf("Expected success for request 1, got %+v", res)
+	}
+}
+
+func TestProxyHandlerHandshakeNoCapabilities(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+	)
+	s := httptest.NewServer(handler)
+	defer s.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{proxy.SubprotocolJSON}}
+	ws, _, err := dialer.Dial(httpToWS(t, s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	if got := ws.Subprotocol(); got != proxy.SubprotocolJSON {
+		t.Fatalf("Expected subprotocol %s, got %q", proxy.SubprotocolJSON, got)
+	}
+
+	// the hello refuses JSON negotiated on upgrade, so the session is binary
+	ack := sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+	if ack.Capabilities != 0 {
+		t.Fatalf("Expected no capabilities accepted, got %+v", ack)
+	}
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDEUR"})
+	want := proxy.OrderResponse{ID: 1, Code: 0}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestProxyHandlerHandshakeUnsupportedVersion(t *testing.T) {
+	handler := NewProxyHandler(
+		"localhost:0",
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeHello(proxy.Hello{Version: 0})); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseProtocolError) {
+		t.Fatalf("Expected protocol error close, got %v", err)
+	}
+}
+
+func TestProxyHandlerTradingHalt(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	trading, err := service.NewTradingService(nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trading.Halt("USDRUB")
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithTradingService(trading),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	order := proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"}
+	sendMessage(t, ws, order)
+	if got := receiveWSMessage(t, ws); got.Code != uint16(model.ResultCodeInstrumentHalted) {
+		t.Fatalf("Expected code %d, got %d", model.ResultCodeInstrumentHalted, got.Code)
+	}
+
+	trading.Resume("USDRUB")
+	order.ID = 2
+	sendMessage(t, ws, order)
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+}
+
+func TestProxyHandlerUpstreamTimeout(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {DropRate: 1},
+			"XLMEUR": {Latency: mockserver.Latency{Mean: mockserver.Duration(150 * time.Millisecond)}},
+		},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(1, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(50*time.Millisecond),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	// the reservation of the unanswered order is released, so the second
+	// order isn't rejected by N and times out too
+	for id := uint32(1); id <= 2; id++ {
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+		want := proxy.OrderResponse{ID: id, Code: uint16(model.ResultCodeUpstreamTimeout)}
+		if got := receiveWSMessage(t, ws); got != want {
+			t.Fatalf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	// the late response is dropped
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "XLMEUR"})
+	want := proxy.OrderResponse{ID: 3, Code: uint16(model.ResultCodeUpstreamTimeout)}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	ws.SetReadDeadline(time.Now().Add(300 * time.Millisecond))
+	if _, m, err := ws.ReadMessage(); err == nil {
+		t.Fatalf("Expected late response to be dropped, got %+v", proxy.DecodeOrderResponse(m))
+	}
+}
+
+func TestProxyHandlerUpstreamTimeoutReusedID(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"USDRUB": {DropRate: 1}},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(2, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(50*time.Millisecond),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	// both requests reusing the ID time out and release their reservations,
+	// so the next pair isn't rejected by N
+	for _, id := range []uint32{1, 2} {
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+		want := proxy.OrderResponse{ID: id, Code: uint16(model.ResultCodeUpstreamTimeout)}
+		for i := 0; i < 2; i++ {
+			if got := receiveWSMessage(t, ws); got != want {
+				t.Fatalf("Expected %+v, got %+v", want, got)
+			}
+		}
+	}
+}
+
+func TestProxyHandlerUpstreamTimeoutAfterDisconnect(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"USDRUB": {DropRate: 1}},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(1, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(30*time.Millisecond),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	time.Sleep(10 * time.Millisecond)
+	ws.Close()
+
+	// the order may have been executed, so it keeps its reservation
+	time.Sleep(100 * time.Millisecond)
+	ws, _, err := websocket.DefaultDialer.Dial(httpToWS(t, s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	want := proxy.OrderResponse{ID: 2, Code: uint16(model.ResultCodeOpenOrdersExceedes)}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestProxyHandlerFailover(t *testing.T) {
+	down := mockserver.NewTestServer(mockserver.Scenario{})
+	downAddr := backendHost(t, down)
+	down.Close()
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	pool := upstream.NewPool([]string{downAddr, backendHost(t, backend)}, false)
+	handler := NewProxyHandler(
+		"",
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithBackends(pool),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+	if got := pool.Candidates(4815); got[0] != backendHost(t, backend) {
+		t.Fatalf("Expected unreachable backend to be marked down, got %v", got)
+	}
+}
+
+func TestProxyHandlerRoutes(t *testing.T) {
+	// each backend rejects the instruments which aren't routed to it
+	def := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"USDRUB": {RejectRate: 1, RejectCode: 1}},
+	})
+	defer def.Close()
+	usd := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"EURUSD": {RejectRate: 1, RejectCode: 2}},
+	})
+	defer usd.Close()
+	down := mockserver.NewTestServer(mockserver.Scenario{})
+	down.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, def),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(1, 3000),
+		service.NewClientsService(),
+		WithRoutes(
+			upstream.Route{Pattern: "USD*", Backends: upstream.NewPool([]string{backendHost(t, usd)}, false)},
+			upstream.Route{Pattern: "XLMEUR", Backends: upstream.NewPool([]string{backendHost(t, down)}, false)},
+		),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	got := map[uint32]uint16{}
+	for i := 0; i < 2; i++ {
+		res := receiveWSMessage(t, ws)
+		got[res.ID] = res.Code
+	}
+	if got[1] != 0 || got[2] != 0 {
+		t.Fatalf("Expected both orders to be accepted by their backends, got %v", got)
+	}
+
+	// the reservation of the order that can't be routed is released,
+	// so the second order isn't rejected by N
+	for id := uint32(3); id <= 4; id++ {
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "XLMEUR"})
+		want := proxy.OrderResponse{ID: id, Code: uint16(model.ResultCodeUpstreamUnavailable)}
+		if got := receiveWSMessage(t, ws); got != want {
+			t.Fatalf("Expected %+v, got %+v", want, got)
+		}
+	}
+}
+
+func TestProxyHandlerRouteLost(t *testing.T) {
+	def := mockserver.NewTestServer(mockserver.Scenario{})
+	defer def.Close()
+	usd := mockserver.NewTestServer(mockserver.Scenario{DisconnectAfter: 2})
+	defer usd.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, def),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(2, 3000),
+		service.NewClientsService(),
+		WithRoutes(upstream.Route{Pattern: "USD*", Backends: upstream.NewPool([]string{backendHost(t, usd)}, false)}),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+	// the route's server disconnects leaving the order unanswered
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	want := proxy.OrderResponse{ID: 2, Code: uint16(model.ResultCodeUpstreamUnavailable)}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	ws.SetReadDeadline(time.Time{})
+
+	// the session keeps trading on the default route and the lost route
+	// reconnects, the failed order is rolled back so N isn't reached
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+}
+
+func TestProxyHandlerBreaker(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"USDRUB": {DropRate: 1}},
+	})
+	defer backend.Close()
+
+	breakers := upstream.NewBreakers(upstream.BreakerConfig{Window: 2, MinRequests: 2, FailureRate: 1, OpenFor: time.Minute})
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(30*time.Millisecond),
+		WithBreakers(breakers),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	for id := uint32(1); id <= 2; id++ {
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+		want := proxy.OrderResponse{ID: id, Code: uint16(model.ResultCodeUpstreamTimeout)}
+		if got := receiveWSMessage(t, ws); got != want {
+			t.Fatalf("Expected %+v, got %+v", want, got)
+		}
+	}
+
+	// the open breaker fails requests right away, even the ones
+	// the server would answer
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	want := proxy.OrderResponse{ID: 3, Code: uint16(model.ResultCodeUpstreamUnavailable)}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	if got := breakers.States()[upstream.DefaultRouteName]; got != "open" {
+		t.Fatalf("Expected open breaker, got %s", got)
+	}
+}
+
+func TestWriteRequestsToUpstreamFailed(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	breakers := upstream.NewBreakers(upstream.BreakerConfig{Window: 2, MinRequests: 2, FailureRate: 1, OpenFor: time.Minute})
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithBreakers(breakers),
+	)
+	ws, _, err := websocket.DefaultDialer.Dial(httpToWS(t, backend.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ws.Close()
+
+	reqs := []proxy.OrderRequest{
+		{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"},
+		{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"},
+	}
+	gen := breakers.Get("").Generation()
+	conn := &upstreamConn{ws: ws}
+	if failed := handler.writeRequestsToUpstream(&session{}, conn, reqs, []uint64{gen, gen}); len(failed) != 2 {
+		t.Fatalf("Expected both requests failed, got %v", failed)
+	}
+	if got := breakers.States()[upstream.DefaultRouteName]; got != "open" {
+		t.Fatalf("Expected write errors to open the breaker, got %s", got)
+	}
+}
+
+func TestProxyHandlerBreakerAfterDisconnect(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{"USDRUB": {DropRate: 1}},
+	})
+	defer backend.Close()
+
+	breakers := upstream.NewBreakers(upstream.BreakerConfig{Window: 1, MinRequests: 1, FailureRate: 1, OpenFor: time.Minute})
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(30*time.Millisecond),
+		WithBreakers(breakers),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	time.Sleep(10 * time.Millisecond)
+	ws.Close()
+
+	// requests of a client gone aren't failures of the server
+	time.Sleep(100 * time.Millisecond)
+	if got := breakers.States()[upstream.DefaultRouteName]; got != "closed" {
+		t.Fatalf("Expected closed breaker, got %s", got)
+	}
+}
+
+func TestProxyHandlerBreakerStaleResponse(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {DropRate: 1},
+			"EURUSD": {Latency: mockserver.Latency{Mean: mockserver.Duration(150 * time.Millisecond)}},
+		},
+	})
+	defer backend.Close()
+
+	breakers := upstream.NewBreakers(upstream.BreakerConfig{Window: 2, MinRequests: 2, FailureRate: 1, OpenFor: 10 * time.Millisecond, Probes: 1})
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithUpstreamTimeout(200*time.Millisecond),
+		WithBreakers(breakers),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	time.Sleep(100 * time.Millisecond)
+	// answered once the breaker is half-open
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	// the timeouts trip the breaker in either order
+	for i := 0; i < 2; i++ {
+		if got := receiveWSMessage(t, ws); got.Code != uint16(model.ResultCodeUpstreamTimeout) {
+			t.Fatalf("Expected timeout, got %+v", got)
+		}
+	}
+
+	// the probe is never answered
+	time.Sleep(20 * time.Millisecond)
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	want := proxy.OrderResponse{ID: 3, Code: uint16(model.ResultCodeSuccess)}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	if got := breakers.States()[upstream.DefaultRouteName]; got != "half-open" {
+		t.Fatalf("Expected breaker to stay half-open until the probe is answered, got %s", got)
+	}
+}
+
+func TestProxyHandlerInflightWindow(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {Latency: mockserver.Latency{Mean: mockserver.Duration(50 * time.Millisecond)}},
+		},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(3, 3000),
+		service.NewClientsService(),
+		WithInflightWindow(1, 1),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	// the first order is forwarded, the second waits in the queue
+	// and the third is rejected
+	for id := uint32(1); id <= 3; id++ {
+		sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	}
+	want := []proxy.OrderResponse{
+		{ID: 3, Code: uint16(model.ResultCodeTooManyInflight)},
+		{ID: 1, Code: 0},
+		{ID: 2, Code: 0},
+	}
+	for _, w := range want {
+		if got := receiveWSMessage(t, ws); got != w {
+			t.Fatalf("Expected %+v, got %+v", w, got)
+		}
+	}
+
+	// the reservation of the rejected order is released, so N isn't reached
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+}
+
+func TestProxyHandlerClosePriority(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {Latency: mockserver.Latency{Mean: mockserver.Duration(50 * time.Millisecond)}},
+		},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithInflightWindow(1, 1),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 5, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	if got := receiveWSMessage(t, ws); got.ID != 5 || got.Code != 0 {
+		t.Fatalf("Expected open of EURUSD accepted, got %+v", got)
+	}
+
+	// opens fill the window and the queue, then the close of another
+	// instrument jumps over them
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 2, OrderKind: 1, Volume: 100, Instrument: "EURUSD"})
+	if got, want := receiveWSMessage(t, ws), (proxy.OrderResponse{ID: 3, Code: uint16(model.ResultCodeTooManyInflight)}); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	// the close is answered along with the first open, while the queued
+	// open waits for both of them
+	got := map[uint32]uint16{}
+	for i := 0; i < 2; i++ {
+		res := receiveWSMessage(t, ws)
+		got[res.ID] = res.Code
+	}
+	if code, ok := got[4]; !ok || code != 0 {
+		t.Fatalf("Expected the close to be answered before the queued open, got %v", got)
+	}
+	if res := receiveWSMessage(t, ws); res.ID != 2 || res.Code != 0 {
+		t.Fatalf("Expected the queued open to be answered last, got %+v", res)
+	}
+}
+
+func TestProxyHandlerCloseBehindQueuedOpen(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {Latency: mockserver.Latency{Mean: mockserver.Duration(50 * time.Millisecond)}},
+		},
+	})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithInflightWindow(1, 1),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	// the close waits for the queued open of the instrument,
+	// even though the queue is full
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 2, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	for _, id := range []uint32{1, 2, 3} {
+		if got := receiveWSMessage(t, ws); got.ID != id || got.Code != 0 {
+			t.Fatalf("Expected request %d answered, got %+v", id, got)
+		}
+	}
+}
+
+func TestProxyHandlerKillSwitch(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	clients := service.NewClientsService()
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000, service.WithRules(clients)),
+		clients,
+		WithKillSwitch(clients),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+
+	// opens are blocked while the position can still be closed
+	if err := clients.Block(4815, service.BlockOpens); err != nil {
+		t.Fatal(err)
+	}
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	want := proxy.OrderResponse{ID: 2, Code: uint16(model.ResultCodeClientBlocked)}
+	if got := receiveWSMessage(t, ws); got != want {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 3, ReqType: 2, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+
+	// blocking everything disconnects the client and refuses it afterwards
+	if err := clients.Block(4815, service.BlockAll); err != nil {
+		t.Fatal(err)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("Expected close with policy violation, got %v", err)
+	}
+
+	ws2, _, err := websocket.DefaultDialer.Dial(httpToWS(t, s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws2.Close()
+	sendMessage(t, ws2, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 2, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	ws2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws2.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("Expected blocked client to be refused, got %v", err)
+	}
+}
+
+func TestProxyHandlerCloseAll(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	clients := service.NewClientsService()
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000, service.WithRules(clients)),
+		clients,
+		WithKillSwitch(clients),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+	sendHello(t, ws, proxy.Hello{Version: proxy.ProtocolV2})
+
+	for i, req := range []proxy.OrderRequest{
+		{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"},
+		{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 200, Instrument: "USDRUB"},
+		{ClientID: 4815, ID: 3, ReqType: 1, OrderKind: 2, Volume: 50, Instrument: "EURUSD"},
+	} {
+		sendMessage(t, ws, req)
+		if got := receiveWSMessage(t, ws); got.Code != 0 {
+			t.Fatalf("%d: Expected code 0, got %d", i, got.Code)
+		}
+	}
+
+	if err := clients.Block(4815, service.BlockAll); err != nil {
+		t.Fatal(err)
+	}
+	want := []ClosedOrder{
+		{Instrument: "EURUSD", OrderKind: model.OrderKindSell, Volume: 50},
+		{Instrument: "USDRUB", OrderKind: model.OrderKindBuy, Volume: 300},
+	}
+	got := handler.CloseAll(4815)
+	if len(got) != len(want) {
+		t.Fatalf("Expected closes %+v, got %+v", want, got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Expected closes %+v, got %+v", want, got)
+		}
+	}
+	if got := handler.CloseAll(4815); len(got) != 0 {
+		t.Fatalf("Expected no closes of a flat client, got %+v", got)
+	}
+
+	// the client is flat once unblocked: nothing to close and the limits are free
+	if err := clients.Unblock(4815); err != nil {
+		t.Fatal(err)
+	}
+	ws2, _, err := websocket.DefaultDialer.Dial(httpToWS(t, s.URL), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws2.Close()
+	sendHello(t, ws2, proxy.Hello{Version: proxy.ProtocolV2})
+	sendMessage(t, ws2, proxy.OrderRequest{ClientID: 4815, ID: 4, ReqType: 2, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	wantRes := proxy.OrderResponse{ID: 4, Code: uint16(model.ResultCodeNoOrderToClose)}
+	if got := receiveWSMessage(t, ws2); got != wantRes {
+		t.Fatalf("Expected %+v, got %+v", wantRes, got)
+	}
+	for id := uint32(5); id < 9; id++ {
+		sendMessage(t, ws2, proxy.OrderRequest{ClientID: 4815, ID: id, ReqType: 1, OrderKind: 1, Volume: 700, Instrument: "USDRUB"})
+		if got := receiveWSMessage(t, ws2); got.Code != 0 {
+			t.Fatalf("%d: Expected code 0, got %d", id, got.Code)
+		}
+	}
+}
+
+func TestProxyHandlerUpgrader(t *testing.T) {
+	backend := mockserver.NewTestServer(mockserver.Scenario{})
+	defer backend.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, backend),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+		WithUpgrader(UpgraderConfig{
+			AllowedOrigins:  []string{"https://trading.example.com"},
+			RequiredHeaders: map[string]string{"X-Api-Key": "secret"},
+			MaxMessageSize:  proxy.OrderRequestFixedLen + 8,
+		}),
+	)
+	s := httptest.NewServer(handler)
+	defer s.Close()
+	wsURL := httpToWS(t, s.URL)
+
+	cases := []struct {
+		name       string
+		header     http.Header
+		wantStatus int
+	}{
+		{
+			name:       "missing header",
+			header:     http.Header{"Origin": {"https://trading.example.com"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "invalid header",
+			header:     http.Header{"X-Api-Key": {"guess"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "disallowed origin",
+			header:     http.Header{"X-Api-Key": {"secret"}, "Origin": {"https://evil.example.com"}},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "allowed origin",
+			header:     http.Header{"X-Api-Key": {"secret"}, "Origin": {"https://trading.example.com"}},
+			wantStatus: http.StatusSwitchingProtocols,
+		},
+	}
+	for _, tc := range cases {
+		ws, res, err := websocket.DefaultDialer.Dial(wsURL, tc.header)
+		if res == nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if res.StatusCode != tc.wantStatus {
+			t.Fatalf("%s: expected status %d, got %d", tc.name, tc.wantStatus, res.StatusCode)
+		}
+		if ws != nil {
+			ws.Close()
+		}
+	}
+
+	ws, _, err := websocket.DefaultDialer.Dial(wsURL, http.Header{"X-Api-Key": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	if got := receiveWSMessage(t, ws); got.Code != 0 {
+		t.Fatalf("Expected code 0, got %d", got.Code)
+	}
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 2, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUBUSDRUB"})
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseMessageTooBig) {
+		t.Fatalf("Expected close with message too big, got %v", err)
+	}
+}
+
+func TestProxyHandlerNoBackends(t *testing.T) {
+	down := mockserver.NewTestServer(mockserver.Scenario{})
+	down.Close()
+
+	handler := NewProxyHandler(
+		backendHost(t, down),
+		adapter.NewOrderAdapter(),
+		service.NewOrdersService(4, 3000),
+		service.NewClientsService(),
+	)
+	s, ws := newWSServer(t, handler)
+	defer s.Close()
+	defer ws.Close()
+
+	sendMessage(t, ws, proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Fatalf("Expected close with try again later, got %v", err)
+	}
+}
+
+// sendHello makes the handshake and returns the hello frame of the proxy
+func sendHello(t *testing.T, ws *websocket.Conn, hello proxy.Hello) proxy.Hello {
+	t.Helper()
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeHello(hello)); err != nil {
+		t.Fatal(err)
+	}
+	_, m, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ack, err := proxy.DecodeHello(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ack
+}
+
+func backendHost(t *testing.T, s *httptest.Server) string {
+	t.Helper()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u.Host
+}
+
 func newWSServer(t *testing.T, h http.Handler) (*httptest.Server, *websocket.Conn) {
 	t.Helper()
 
@@ -145,3 +1464,19 @@ func httpToWS(t *testing.T, u string) string {
 
 	return wsURL.String()
 }
+
+func TestBinaryCodecZeroAllocs(t *testing.T) {
+	proxy.InternInstruments("USDRUB")
+	codec := codecFor(proxy.SubprotocolBinary)
+	message := proxy.EncodeOrderRequest(proxy.OrderRequest{ClientID: 4815, ID: 1, ReqType: 1, OrderKind: 1, Volume: 100, Instrument: "USDRUB"})
+
+	allocs := testing.AllocsPerRun(100, func() {
+		reqs, err := codec.decodeRequests(message)
+		if err != nil || len(reqs) != 1 || reqs[0].Instrument != "USDRUB" {
+			t.Fatalf("unexpected requests %+v: %v", reqs, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
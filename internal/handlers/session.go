@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/model"
+)
+
+// session is a single client connection proxied to the order server
+type session struct {
+	id       uint64
+	clientID uint32
+	clientWS *websocket.Conn
+	// upstreams are connections to order servers by route pattern,
+	// the default route has an empty pattern
+	upstreams   map[string]*upstreamConn
+	upstreamsMu sync.Mutex
+	// closing is set once the proxy closes the server connections itself
+	closing int32
+	// codec is chosen by the subprotocol negotiated with the client
+	// or by capabilities announced in the hello frame
+	codec clientCodec
+	// version is the protocol version of the client
+	version uint16
+	// frameType is the type of the last frame received from the client,
+	// responses to the client mirror it
+	frameType int32
+	// pending tracks requests forwarded to the server, nil if
+	// upstream timeouts are disabled
+	pending *pendingRequests
+	// window bounds requests in flight, nil if unbounded
+	window *inflightWindow
+	// clientMu serializes writes to the client, as both rejections and
+	// responses relayed from the server are written concurrently
+	clientMu sync.Mutex
+	// done is closed once the client reader is over
+	done chan struct{}
+}
+
+func newSession(id uint64, clientWS *websocket.Conn) *session {
+	return &session{
+		id:        id,
+		clientWS:  clientWS,
+		upstreams: make(map[string]*upstreamConn),
+		codec:     codecFor(clientWS.Subprotocol()),
+		version:   proxy.ProtocolV1,
+		frameType: websocket.BinaryMessage,
+		done:      make(chan struct{}),
+	}
+}
+
+// clientFrameType returns the frame type the client uses
+func (s *session) clientFrameType() int {
+	return int(atomic.LoadInt32(&s.frameType))
+}
+
+// setClientFrameType remembers the type of a frame received from the client.
+// Both text and binary frames are accepted for compatibility.
+func (s *session) setClientFrameType(mt int) {
+	atomic.StoreInt32(&s.frameType, int32(mt))
+}
+
+// writeToClient sends the message to the client in the frame type it uses
+func (s *session) writeToClient(message []byte) error {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	return writeToConn(s.clientWS, "client", s.clientFrameType(), message)
+}
+
+// closeServer closes the connections to the servers once the client is gone
+func (s *session) closeServer() {
+	atomic.StoreInt32(&s.closing, 1)
+	s.upstreamsMu.Lock()
+	defer s.upstreamsMu.Unlock()
+	for _, conn := range s.upstreams {
+		conn.ws.Close()
+	}
+}
+
+// serverClosed tells whether the proxy has closed the server connections
+func (s *session) serverClosed() bool {
+	return atomic.LoadInt32(&s.closing) == 1
+}
+
+// wireCode translates result code to the one known by the client's protocol version
+func (s *session) wireCode(code model.ResultCode) uint16 {
+	if s.version < proxy.ProtocolV2 && code > model.ResultCodeOther {
+		return uint16(model.ResultCodeOther)
+	}
+	return uint16(code)
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// UpgraderConfig configures WebSocket upgrades of client connections
+type UpgraderConfig struct {
+	// AllowedOrigins are origins like "https://example.com" browsers may
+	// connect from, "*" allows any. Requests without Origin header don't come
+	// from browsers and are always allowed. If empty, the origin must match
+	// the host, the default of websocket.Upgrader.
+	AllowedOrigins []string
+	// RequiredHeaders must be present in upgrade requests, with the value
+	// unless it's empty
+	RequiredHeaders map[string]string
+	// MaxMessageSize is the limit of a client frame in bytes, unlimited if zero.
+	// A binary order takes 18 bytes plus the instrument, like 24 for USDRUB,
+	// while JSON and batch frames are larger.
+	MaxMessageSize int64
+	// ReadBufferSize and WriteBufferSize are sizes of I/O buffers,
+	// 4096 bytes if zero
+	ReadBufferSize  int
+	WriteBufferSize int
+	// EnableCompression negotiates permessage-deflate with clients
+	// at CompressionLevel, the flate default if zero
+	EnableCompression bool
+	CompressionLevel  int
+}
+
+// originChecker returns the CheckOrigin function of websocket.Upgrader
+// allowing the origins
+func originChecker(allowed []string) func(r *http.Request) bool {
+	origins := make(map[string]struct{}, len(allowed))
+	for _, origin := range allowed {
+		origins[strings.ToLower(origin)] = struct{}{}
+	}
+	_, anyOrigin := origins["*"]
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		if origin == "" || anyOrigin {
+			return true
+		}
+		_, ok := origins[strings.ToLower(origin)]
+		return ok
+	}
+}
+
+// checkHeaders returns an error if the request misses a required header
+func (p *ProxyHandler) checkHeaders(r *http.Request) error {
+	for name, want := range p.requiredHeaders {
+		got, ok := r.Header[http.CanonicalHeaderKey(name)]
+		if !ok || want != "" && (len(got) == 0 || got[0] != want) {
+			return fmt.Errorf("missing or invalid header %s", name)
+		}
+	}
+	return nil
+}
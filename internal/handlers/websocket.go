@@ -1,30 +1,289 @@
 package handlers
 
 import (
+	"errors"
 	"log"
 	"net/url"
+	"sync"
 
 	"github.com/gorilla/websocket"
 	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/capture"
 )
 
-func (p *ProxyHandler) mustGetServerConn() *websocket.Conn {
-	u := url.URL{Scheme: "ws", Host: p.backendAddr, Path: "/connect"}
-	serverWS, _, err := p.dialer.Dial(u.String(), nil)
+// singleBackend is the only order server, it's never marked down
+type singleBackend string
+
+func (b singleBackend) Candidates(uint32) []string { return []string{string(b)} }
+
+func (singleBackend) MarkDown(string) {}
+
+// upstreamConn is a session's connection to an order server
+type upstreamConn struct {
+	ws *websocket.Conn
+	// route is the pattern of the route the connection serves
+	route string
+	// addr is the address of the backend the connection is made to
+	addr     string
+	backends backendPool
+	// mu serializes writes, as requests are forwarded both by the client
+	// reader and by goroutines freeing slots of the in-flight window
+	mu sync.Mutex
+	// buf is reused to encode requests
+	buf []byte
+	// sent holds requests written to the server and not answered yet
+	// by ID oldest first, to fail them if the connection is lost
+	sent   map[uint32][]proxy.OrderRequest
+	sentMu sync.Mutex
+	// lost is set under the write lock once the connection is lost
+	lost bool
+}
+
+// track remembers requests about to be written to the server
+func (c *upstreamConn) track(reqs []proxy.OrderRequest) {
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	if c.sent == nil {
+		c.sent = make(map[uint32][]proxy.OrderRequest)
+	}
+	for _, req := range reqs {
+		c.sent[req.ID] = append(c.sent[req.ID], req)
+	}
+}
+
+// untrack forgets the oldest requests with the IDs, answered by the server
+// or failed to be written
+func (c *upstreamConn) untrack(ids []uint32) {
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	for _, id := range ids {
+		switch reqs := c.sent[id]; len(reqs) {
+		case 0:
+		case 1:
+			delete(c.sent, id)
+		default:
+			c.sent[id] = reqs[1:]
+		}
+	}
+}
+
+// lose marks the connection lost, so nothing is written to it anymore,
+// and returns requests the server hasn't answered
+func (c *upstreamConn) lose() []proxy.OrderRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lost = true
+	c.sentMu.Lock()
+	defer c.sentMu.Unlock()
+	var res []proxy.OrderRequest
+	for _, reqs := range c.sent {
+		res = append(res, reqs...)
+	}
+	c.sent = nil
+	return res
+}
+
+// connectUpstream connects the session to the route's backends
+// and starts relaying responses of the server to the client,
+// it's called under the upstreams lock of the session
+func (p *ProxyHandler) connectUpstream(s *session, route string, backends backendPool) (*upstreamConn, error) {
+	conn, err := p.getServerConn(s.clientID, backends)
 	if err != nil {
-		log.Fatal("dial to a server:", err)
+		p.upstreamFailed(route, p.upstreamGeneration(route))
+		return nil, err
 	}
-	return serverWS
+	conn.route = route
+	s.upstreams[route] = conn
+	go p.serverToClient(s, conn)
+	return conn, nil
 }
 
-func (p *ProxyHandler) writeErrorToClient(clientWS *websocket.Conn, ID uint32, originalErr error) {
-	log.Printf("error ID %d: %v", ID, originalErr)
+// routeConn returns the session's connection to the route's backends,
+// connecting on the first request
+func (p *ProxyHandler) routeConn(s *session, route string, backends backendPool) (*upstreamConn, error) {
+	s.upstreamsMu.Lock()
+	defer s.upstreamsMu.Unlock()
+	if conn, ok := s.upstreams[route]; ok {
+		return conn, nil
+	}
+	if s.serverClosed() {
+		return nil, errSessionClosed
+	}
+	conn, err := p.connectUpstream(s, route, backends)
+	if err != nil {
+		log.Printf("connect client %d to a server of route %q: %v", s.clientID, route, err)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// getServerConn connects to the first reachable backend for the client,
+// backends failing to connect are marked down
+func (p *ProxyHandler) getServerConn(clientID uint32, backends backendPool) (*upstreamConn, error) {
+	dialer := *p.dialer
+	if p.upstreamBatch {
+		// the server may decline, then orders are sent one per frame
+		dialer.Subprotocols = []string{proxy.SubprotocolBatch}
+	}
+	var err error
+	for _, addr := range backends.Candidates(clientID) {
+		u := url.URL{Scheme: "ws", Host: addr, Path: "/connect"}
+		var serverWS *websocket.Conn
+		if serverWS, _, err = dialer.Dial(u.String(), nil); err == nil {
+			return &upstreamConn{ws: serverWS, addr: addr, backends: backends}, nil
+		}
+		log.Printf("dial to a server %s: %v", addr, err)
+		backends.MarkDown(addr)
+	}
+	if err == nil {
+		err = errNoBackends
+	}
+	return nil, err
+}
 
-	res := proxy.OrderResponse{
-		ID:   ID,
-		Code: uint16(p.adapter.GetResultCodeFromErr(originalErr)),
+var (
+	errNoBackends    = errors.New("no order server backends")
+	errSessionClosed = errors.New("session is closed")
+)
+
+func (p *ProxyHandler) writeErrorsToClient(s *session, rejected []rejection) {
+	if len(rejected) == 0 {
+		return
+	}
+	res := make([]proxy.OrderResponse, len(rejected))
+	for i, r := range rejected {
+		log.Printf("error ID %d: %v", r.id, r.err)
+		res[i] = proxy.OrderResponse{
+			ID:   r.id,
+			Code: s.wireCode(p.adapter.GetResultCodeFromErr(r.err)),
+		}
+	}
+
+	messages, err := s.codec.encodeResponses(res)
+	if err != nil {
+		log.Printf("encode responses: %v", err)
+		return
+	}
+	for _, message := range messages {
+		p.record(s, capture.DirectionProxy, s.clientFrameType(), message)
+		if err := s.writeToClient(message); err != nil {
+			return
+		}
+	}
+}
+
+// writeResponsesToClient relays responses from the server in the client's format
+func (p *ProxyHandler) writeResponsesToClient(s *session, res []proxy.OrderResponse) error {
+	messages, err := s.codec.encodeResponses(res)
+	if err != nil {
+		log.Printf("encode responses: %v", err)
+		return err
+	}
+	for _, message := range messages {
+		if err := s.writeToClient(message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeRequestsToServer starts deadlines of accepted requests and sends them
+// to the servers their instruments are routed to. It returns requests that
+// can't be routed, are stopped by an open breaker or can't be written.
+func (p *ProxyHandler) writeRequestsToServer(s *session, reqs []proxy.OrderRequest) []proxy.OrderRequest {
+	if len(reqs) == 0 {
+		return nil
+	}
+	var (
+		conns  []*upstreamConn
+		routed = make(map[*upstreamConn][]proxy.OrderRequest)
+		gens   = make(map[*upstreamConn][]uint64)
+		failed []proxy.OrderRequest
+	)
+	for _, req := range reqs {
+		route, backends := p.router.Route(req.Instrument)
+		gen, ok := p.allowUpstream(route)
+		p.trackPending(s, req, route, gen)
+		if !ok {
+			failed = append(failed, req)
+			continue
+		}
+		conn, err := p.routeConn(s, route, backends)
+		if err != nil {
+			failed = append(failed, req)
+			continue
+		}
+		if _, ok := routed[conn]; !ok {
+			conns = append(conns, conn)
+		}
+		routed[conn] = append(routed[conn], req)
+		gens[conn] = append(gens[conn], gen)
+	}
+	for _, conn := range conns {
+		failed = append(failed, p.writeRequestsToUpstream(s, conn, routed[conn], gens[conn])...)
+	}
+	return failed
+}
+
+// writeRequestsToUpstream sends requests to the server, in a single
+// frame if batching was negotiated with it. It returns requests that
+// can't be written, as the connection is lost or fails. Write errors are failures of the breaker generations
+// the requests were let through in unless the session is over.
+func (p *ProxyHandler) writeRequestsToUpstream(s *session, conn *upstreamConn, reqs []proxy.OrderRequest, gens []uint64) (failed []proxy.OrderRequest) {
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.lost {
+		return reqs
+	}
+	// requests are tracked before they're written, as the server may answer right away
+	conn.track(reqs)
+	defer func() { conn.untrack(requestIDs(failed)) }()
+	if conn.ws.Subprotocol() == proxy.SubprotocolBatch {
+		message, err := proxy.EncodeOrderRequestBatch(reqs)
+		if err != nil {
+			log.Printf("encode batch: %v", err)
+			return reqs
+		}
+		if err := writeToConn(conn.ws, "server", websocket.BinaryMessage, message); err != nil {
+			for _, gen := range gens {
+				p.writeFailed(s, conn, gen)
+			}
+			return reqs
+		}
+		log.Printf("sent to server: %v", reqs)
+		return nil
+	}
+
+	for i, req := range reqs {
+		// the buffer is reused, the connection copies the message on write
+		conn.buf = proxy.AppendOrderRequest(conn.buf[:0], req)
+		if err := writeToConn(conn.ws, "server", websocket.BinaryMessage, conn.buf); err != nil {
+			p.writeFailed(s, conn, gens[i])
+			failed = append(failed, req)
+			continue
+		}
+		log.Printf("sent to server: %v", req)
+	}
+	return failed
+}
+
+// writeFailed records a request the server connection failed to take
+func (p *ProxyHandler) writeFailed(s *session, conn *upstreamConn, gen uint64) {
+	if !s.serverClosed() {
+		p.upstreamFailed(conn.route, gen)
+	}
+}
+
+// decodeServerResponses decodes a frame from the server according
+// to the subprotocol negotiated with it
+func decodeServerResponses(serverWS *websocket.Conn, message []byte) ([]proxy.OrderResponse, error) {
+	if serverWS.Subprotocol() == proxy.SubprotocolBatch {
+		return proxy.DecodeOrderResponseBatch(message)
+	}
+	if len(message) < proxy.OrderResponseLen {
+		return nil, proxy.ErrMessageTooShort
 	}
-	writeToConn(clientWS, "client", websocket.TextMessage, proxy.EncodeOrderResponse(res))
+	return []proxy.OrderResponse{proxy.DecodeOrderResponse(message)}, nil
 }
 
 func writeToConn(conn *websocket.Conn, connType string, mt int, message []byte) error {
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"test.task/backend/proxy/internal/metrics"
+)
+
+// AdmissionConfig limits WebSocket sessions accepted by the server,
+// zero values are unlimited
+type AdmissionConfig struct {
+	// MaxSessions is the number of concurrent sessions
+	MaxSessions int
+	// MaxSessionsPerIP is the number of concurrent sessions from a source IP
+	MaxSessionsPerIP int
+	// UpgradeRate is the number of upgrades per second
+	// with bursts of UpgradeBurst, which is UpgradeRate if zero
+	UpgradeRate  float64
+	UpgradeBurst int
+	// CloseFrame rejects connections with a close frame holding the reason
+	// after the upgrade instead of HTTP 503 before it
+	CloseFrame bool
+	// Upgrader upgrades connections rejected with a close frame. It should
+	// accept the origins and subprotocols of the handler, so clients get
+	// the reason, the server's upgrader if nil.
+	Upgrader *websocket.Upgrader
+}
+
+// reasons of rejection, used as metric keys
+const (
+	rejectSessions = "sessions"
+	rejectIP       = "ip"
+	rejectRate     = "rate"
+)
+
+var rejectMessages = map[string]string{
+	rejectSessions: "too many sessions",
+	rejectIP:       "too many sessions from the address",
+	rejectRate:     "too many connection attempts",
+}
+
+// admission counts sessions served by the next handler, a session lasts
+// until the handler returns, and rejects upgrades beyond the limits
+type admission struct {
+	sync.Mutex
+	next     http.Handler
+	cfg      AdmissionConfig
+	upgrader *websocket.Upgrader
+	sessions int
+	perIP    map[string]int
+	// tokens is the bucket of the upgrade rate refilled since the last upgrade
+	tokens float64
+	last   time.Time
+	now    func() time.Time
+}
+
+func newAdmission(next http.Handler, cfg AdmissionConfig, upgrader *websocket.Upgrader) *admission {
+	if cfg.UpgradeBurst < 1 {
+		cfg.UpgradeBurst = int(cfg.UpgradeRate)
+		if cfg.UpgradeBurst < 1 {
+			cfg.UpgradeBurst = 1
+		}
+	}
+	return &admission{
+		next:     next,
+		cfg:      cfg,
+		upgrader: upgrader,
+		perIP:    make(map[string]int),
+		tokens:   float64(cfg.UpgradeBurst),
+		now:      time.Now,
+	}
+}
+
+func (a *admission) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !websocket.IsWebSocketUpgrade(r) {
+		a.next.ServeHTTP(w, r)
+		return
+	}
+	ip := sourceIP(r)
+	if reason := a.admit(ip); reason != "" {
+		log.Printf("connection from %s rejected: %s", ip, rejectMessages[reason])
+		metrics.RejectedConnections.Add(reason, 1)
+		a.reject(w, r, reason)
+		return
+	}
+	defer a.release(ip)
+	a.next.ServeHTTP(w, r)
+}
+
+// admit takes a session slot for the address,
+// it returns the reason of rejection if there's none
+func (a *admission) admit(ip string) string {
+	a.Lock()
+	defer a.Unlock()
+	if a.cfg.MaxSessions > 0 && a.sessions >= a.cfg.MaxSessions {
+		return rejectSessions
+	}
+	if a.cfg.MaxSessionsPerIP > 0 && a.perIP[ip] >= a.cfg.MaxSessionsPerIP {
+		return rejectIP
+	}
+	if a.cfg.UpgradeRate > 0 {
+		now := a.now()
+		if !a.last.IsZero() {
+			a.tokens += now.Sub(a.last).Seconds() * a.cfg.UpgradeRate
+		}
+		if a.tokens > float64(a.cfg.UpgradeBurst) {
+			a.tokens = float64(a.cfg.UpgradeBurst)
+		}
+		a.last = now
+		if a.tokens < 1 {
+			return rejectRate
+		}
+		a.tokens--
+	}
+	a.sessions++
+	a.perIP[ip]++
+	metrics.Sessions.Set(int64(a.sessions))
+	return ""
+}
+
+func (a *admission) release(ip string) {
+	a.Lock()
+	defer a.Unlock()
+	a.sessions--
+	if a.perIP[ip]--; a.perIP[ip] == 0 {
+		delete(a.perIP, ip)
+	}
+	metrics.Sessions.Set(int64(a.sessions))
+}
+
+func (a *admission) reject(w http.ResponseWriter, r *http.Request, reason string) {
+	message := rejectMessages[reason]
+	if !a.cfg.CloseFrame {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, message, http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := a.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print("upgrade rejected request:", err)
+		return
+	}
+	defer conn.Close()
+	if err := conn.WriteMessage(
+		websocket.CloseMessage,
+		websocket.FormatCloseMessage(websocket.CloseTryAgainLater, message),
+	); err != nil {
+		log.Println("write close:", err)
+	}
+}
+
+// sourceIP returns the IP address the request came from
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,140 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+)
+
+// holdHandler upgrades connections and holds them until the peer closes
+type holdHandler struct{}
+
+func (holdHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	for _, closeFrame := range []bool{false, true} {
+		upgrader := websocket.Upgrader{}
+		s := httptest.NewServer(newAdmission(holdHandler{}, AdmissionConfig{MaxSessions: 1, CloseFrame: closeFrame}, &upgrader))
+		wsURL := "ws" + strings.TrimPrefix(s.URL, "http")
+
+		first, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, res, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if !closeFrame {
+			if err == nil || res.StatusCode != http.StatusServiceUnavailable {
+				t.Fatalf("expected 503 beyond the limit, got: %v", err)
+			}
+		} else {
+			if err != nil {
+				t.Fatal(err)
+			}
+			second.SetReadDeadline(time.Now().Add(time.Second))
+			if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+				t.Fatalf("expected close frame beyond the limit, got: %v", err)
+			}
+			second.Close()
+		}
+
+		// the slot is freed once the session is over
+		first.Close()
+		time.Sleep(50 * time.Millisecond)
+		third, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+		if err != nil {
+			t.Fatalf("expected connection after the session is over, got: %v", err)
+		}
+		third.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+		if _, _, err := third.ReadMessage(); websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+			t.Fatal("expected connection after the session is over to be admitted")
+		}
+		third.Close()
+		s.Close()
+	}
+}
+
+func TestAdmissionCloseFrameUpgrader(t *testing.T) {
+	upgrader := &websocket.Upgrader{
+		Subprotocols: []string{proxy.SubprotocolJSON},
+		CheckOrigin:  func(r *http.Request) bool { return true },
+	}
+	srv := NewServer("", holdHandler{}, WithAdmission(AdmissionConfig{
+		UpgradeRate: 0.001,
+		CloseFrame:  true,
+		Upgrader:    upgrader,
+	})).(*server)
+	s := httptest.NewServer(srv.handler)
+	defer s.Close()
+	wsURL := "ws" + strings.TrimPrefix(s.URL, "http")
+
+	first, _, err := websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	// a browser on another origin speaking JSON gets the reason
+	header := http.Header{"Origin": {"https://desk.example.com"}}
+	dialer := websocket.Dialer{Subprotocols: []string{proxy.SubprotocolJSON}}
+	second, _, err := dialer.Dial(wsURL, header)
+	if err != nil {
+		t.Fatalf("expected rejected connection to be upgraded, got: %v", err)
+	}
+	defer second.Close()
+	if got := second.Subprotocol(); got != proxy.SubprotocolJSON {
+		t.Fatalf("expected subprotocol %s, got %q", proxy.SubprotocolJSON, got)
+	}
+	second.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := second.ReadMessage(); !websocket.IsCloseError(err, websocket.CloseTryAgainLater) {
+		t.Fatalf("expected close frame with the reason, got: %v", err)
+	}
+}
+
+func TestAdmit(t *testing.T) {
+	now := time.Unix(0, 0)
+	a := newAdmission(holdHandler{}, AdmissionConfig{MaxSessions: 4, MaxSessionsPerIP: 2, UpgradeRate: 1, UpgradeBurst: 2}, &websocket.Upgrader{})
+	a.now = func() time.Time { return now }
+
+	steps := []struct {
+		ip      string
+		release bool
+		after   time.Duration
+		want    string
+	}{
+		{ip: "10.0.0.1"},
+		{ip: "10.0.0.1"},
+		{ip: "10.0.0.2", want: rejectRate},
+		{ip: "10.0.0.2", after: time.Second},
+		{ip: "10.0.0.1", after: time.Second, want: rejectIP},
+		{ip: "10.0.0.3"},
+		{ip: "10.0.0.4", after: time.Second, want: rejectSessions},
+		{ip: "10.0.0.1", release: true},
+		{ip: "10.0.0.4"},
+	}
+	for i, step := range steps {
+		now = now.Add(step.after)
+		if step.release {
+			a.release(step.ip)
+			continue
+		}
+		if got := a.admit(step.ip); got != step.want {
+			t.Fatalf("step %d: expected %q, got %q", i, step.want, got)
+		}
+	}
+}
@@ -27,16 +27,43 @@ type server struct {
 	serv             *http.Server
 	upgrader         websocket.Upgrader
 	dialer           *websocket.Dialer
+	// certFile and keyFile make the server listen for TLS connections
+	certFile, keyFile string
 }
 
-func NewServer(addr string, handler http.Handler) Server {
-	return &server{
+// Option configures optional behaviour of the server
+type Option func(*server)
+
+// WithAdmission makes the server reject WebSocket upgrades beyond the limits
+func WithAdmission(cfg AdmissionConfig) Option {
+	return func(s *server) {
+		upgrader := cfg.Upgrader
+		if upgrader == nil {
+			upgrader = &s.upgrader
+		}
+		s.handler = newAdmission(s.handler, cfg, upgrader)
+	}
+}
+
+// WithTLS makes the server serve HTTPS with the certificate and key files
+func WithTLS(certFile, keyFile string) Option {
+	return func(s *server) {
+		s.certFile, s.keyFile = certFile, keyFile
+	}
+}
+
+func NewServer(addr string, handler http.Handler, opts ...Option) Server {
+	s := &server{
 		handler:          handler,
 		addr:             addr,
 		connectedClients: make(map[uint32]struct{}),
 		upgrader:         websocket.Upgrader{},
 		dialer:           websocket.DefaultDialer,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 // Open will setup a tcp listener and serve the http requests.
@@ -46,6 +73,9 @@ func (s *server) Open() error {
 		Handler: s.handler,
 	}
 	log.Printf("Waiting for connections on %s/", s.addr)
+	if s.certFile != "" {
+		return s.serv.ListenAndServeTLS(s.certFile, s.keyFile)
+	}
 	return s.serv.ListenAndServe()
 }
 
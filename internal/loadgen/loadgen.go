@@ -0,0 +1,260 @@
+package loadgen
+
+import (
+	"errors"
+	"log"
+	"math/rand"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/model"
+)
+
+// Config describes a load test
+type Config struct {
+	// Addr is the proxy address
+	Addr        string
+	Clients     int
+	Instruments []string
+	// Duration is how long clients keep sending requests
+	Duration time.Duration
+	// Interval is a pause between two requests of the same client
+	Interval time.Duration
+	// MaxOpen is how many orders a client keeps open per instrument
+	// before it starts closing them
+	MaxOpen   int
+	MaxVolume float64
+	// Wait is how long to wait for outstanding responses after Duration
+	Wait time.Duration
+	// FirstClientID is the id of the first client, the others follow it
+	FirstClientID uint32
+	Seed          int64
+}
+
+func (cfg Config) validate() error {
+	if cfg.Clients <= 0 {
+		return errors.New("number of clients must be positive")
+	}
+	if len(cfg.Instruments) == 0 {
+		return errors.New("at least one instrument required")
+	}
+	if cfg.MaxOpen <= 0 {
+		return errors.New("max open orders must be positive")
+	}
+	if cfg.Interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+	return nil
+}
+
+// Run spawns clients, waits for them to finish and returns the summary
+func Run(cfg Config) (Report, error) {
+	if err := cfg.validate(); err != nil {
+		return Report{}, err
+	}
+	u := url.URL{Scheme: "ws", Host: cfg.Addr, Path: "/"}
+	stats := newCollector()
+
+	start := time.Now()
+	deadline := start.Add(cfg.Duration)
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		c := &client{
+			id:      cfg.FirstClientID + uint32(i),
+			cfg:     cfg,
+			stats:   stats,
+			rnd:     rand.New(rand.NewSource(cfg.Seed + int64(i))),
+			sentAt:  make(map[uint32]sentRequest),
+			opened:  make(map[string][]proxy.OrderRequest),
+			opening: make(map[string]int),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(u.String(), deadline)
+		}()
+	}
+	wg.Wait()
+
+	return stats.report(cfg, time.Since(start)), nil
+}
+
+type sentRequest struct {
+	req proxy.OrderRequest
+	at  time.Time
+}
+
+// client follows open-then-close sequences on random instruments
+type client struct {
+	sync.Mutex
+	id     uint32
+	cfg    Config
+	stats  *collector
+	rnd    *rand.Rand
+	lastID uint32
+	sentAt map[uint32]sentRequest
+	// opened are confirmed open orders per instrument
+	opened map[string][]proxy.OrderRequest
+	// opening counts open orders sent and not answered yet per instrument
+	opening map[string]int
+}
+
+func (c *client) run(addr string, deadline time.Time) {
+	ws, _, err := websocket.DefaultDialer.Dial(addr, nil)
+	if err != nil {
+		log.Printf("client %d dial: %v", c.id, err)
+		return
+	}
+	defer ws.Close()
+
+	done := make(chan struct{})
+	go c.receive(ws, done)
+
+	ticker := time.NewTicker(c.cfg.Interval)
+	defer ticker.Stop()
+	for time.Now().Before(deadline) {
+		select {
+		case <-done:
+			c.finish()
+			return
+		case <-ticker.C:
+		}
+		req, ok := c.next()
+		if !ok {
+			continue
+		}
+		if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeOrderRequest(req)); err != nil {
+			c.forget(req.ID)
+			c.stats.addSendError()
+			continue
+		}
+		c.stats.addSent()
+	}
+
+	c.waitOutstanding(done)
+	c.finish()
+}
+
+// next picks the next request: opens until MaxOpen orders are opened on
+// an instrument and then closes them, most recent first. Opens not answered
+// yet count towards MaxOpen, so it returns false while they fill the limit
+// and there's nothing to close.
+func (c *client) next() (proxy.OrderRequest, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	instrument := c.cfg.Instruments[c.rnd.Intn(len(c.cfg.Instruments))]
+	opened := c.opened[instrument]
+	full := len(opened)+c.opening[instrument] >= c.cfg.MaxOpen
+
+	var req proxy.OrderRequest
+	switch {
+	case len(opened) > 0 && (full || c.rnd.Intn(2) == 0):
+		req = opened[len(opened)-1]
+		c.opened[instrument] = opened[:len(opened)-1]
+		req.ReqType = uint8(model.RequestTypeClose)
+	case full:
+		return proxy.OrderRequest{}, false
+	default:
+		req = proxy.OrderRequest{
+			ClientID:   c.id,
+			ReqType:    uint8(model.RequestTypeOpen),
+			OrderKind:  uint8(model.OrderKindBuy) + uint8(c.rnd.Intn(2)),
+			Volume:     c.cfg.MaxVolume * c.rnd.Float64(),
+			Instrument: instrument,
+		}
+		c.opening[instrument]++
+	}
+	c.lastID++
+	req.ID = c.lastID
+	c.sentAt[req.ID] = sentRequest{req: req, at: time.Now()}
+	return req, true
+}
+
+func (c *client) receive(ws *websocket.Conn, done chan struct{}) {
+	defer close(done)
+	for {
+		_, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var res proxy.OrderResponse
+		if err := proxy.DecodeOrderResponseInto(message, &res); err != nil {
+			log.Printf("client %d: invalid response %x: %v", c.id, message, err)
+			continue
+		}
+		c.answered(res)
+	}
+}
+
+func (c *client) answered(res proxy.OrderResponse) {
+	c.Lock()
+	defer c.Unlock()
+
+	sent, ok := c.remove(res.ID)
+	if !ok {
+		return
+	}
+	c.stats.addResponse(res.Code, time.Since(sent.at))
+
+	if res.Code != uint16(model.ResultCodeSuccess) {
+		// rejected close leaves the order opened
+		if sent.req.ReqType == uint8(model.RequestTypeClose) {
+			c.opened[sent.req.Instrument] = append(c.opened[sent.req.Instrument], sent.req)
+		}
+		return
+	}
+	if sent.req.ReqType == uint8(model.RequestTypeOpen) {
+		c.opened[sent.req.Instrument] = append(c.opened[sent.req.Instrument], sent.req)
+	}
+}
+
+func (c *client) forget(id uint32) {
+	c.Lock()
+	defer c.Unlock()
+	c.remove(id)
+}
+
+// remove stops waiting for the answer to the request,
+// must be called under the lock
+func (c *client) remove(id uint32) (sentRequest, bool) {
+	sent, ok := c.sentAt[id]
+	if !ok {
+		return sentRequest{}, false
+	}
+	delete(c.sentAt, id)
+	if sent.req.ReqType == uint8(model.RequestTypeOpen) {
+		c.opening[sent.req.Instrument]--
+	}
+	return sent, true
+}
+
+func (c *client) outstanding() int {
+	c.Lock()
+	defer c.Unlock()
+	return len(c.sentAt)
+}
+
+// waitOutstanding waits until every request is answered, Wait elapses
+// or the connection is closed
+func (c *client) waitOutstanding(done chan struct{}) {
+	timeout := time.After(c.cfg.Wait)
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for c.outstanding() > 0 {
+		select {
+		case <-done:
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *client) finish() {
+	c.stats.addUnanswered(c.outstanding())
+}
@@ -0,0 +1,111 @@
+package loadgen
+
+import (
+	"math/rand"
+	"net/url"
+	"testing"
+	"time"
+
+	proxy "test.task/backend/proxy"
+	"test.task/backend/proxy/internal/mockserver"
+	"test.task/backend/proxy/internal/model"
+)
+
+func TestRun(t *testing.T) {
+	s := mockserver.NewTestServer(mockserver.Scenario{
+		Instruments: map[string]mockserver.Behavior{
+			"USDRUB": {RejectRate: 1, RejectCode: 3},
+			"XLMEUR": {DropRate: 1},
+		},
+	})
+	defer s.Close()
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Run(Config{
+		Addr:          u.Host,
+		Clients:       4,
+		Instruments:   []string{"EURUSD", "USDRUB", "XLMEUR"},
+		Duration:      200 * time.Millisecond,
+		Interval:      5 * time.Millisecond,
+		MaxOpen:       3,
+		MaxVolume:     100,
+		Wait:          100 * time.Millisecond,
+		FirstClientID: 1,
+		Seed:          1,
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if report.Sent == 0 {
+		t.Fatal("expected requests to be sent")
+	}
+	if report.Received+report.Unanswered != report.Sent {
+		t.Fatalf("sent %d, received %d, unanswered %d", report.Sent, report.Received, report.Unanswered)
+	}
+	if report.Unanswered == 0 {
+		t.Fatal("expected dropped XLMEUR requests to be unanswered")
+	}
+	for _, code := range []string{"0", "3"} {
+		if report.Codes[code].Count == 0 {
+			t.Fatalf("expected responses with code %s, got %+v", code, report.Codes)
+		}
+	}
+}
+
+func TestRunInvalidConfig(t *testing.T) {
+	if _, err := Run(Config{Clients: 1, MaxOpen: 1, Interval: time.Millisecond}); err == nil {
+		t.Fatal("expected error without instruments")
+	}
+	if _, err := Run(Config{Clients: 1, MaxOpen: 1, Instruments: []string{"USDRUB"}}); err == nil {
+		t.Fatal("expected error without interval")
+	}
+}
+
+func TestNextCountsUnansweredOpens(t *testing.T) {
+	c := &client{
+		id:      1,
+		cfg:     Config{Instruments: []string{"USDRUB"}, MaxOpen: 2, MaxVolume: 100},
+		stats:   newCollector(),
+		rnd:     rand.New(rand.NewSource(1)),
+		sentAt:  make(map[uint32]sentRequest),
+		opened:  make(map[string][]proxy.OrderRequest),
+		opening: make(map[string]int),
+	}
+	for i := 0; i < 2; i++ {
+		if req, ok := c.next(); !ok || req.ReqType != uint8(model.RequestTypeOpen) {
+			t.Fatalf("expected open %d, got %+v", i+1, req)
+		}
+	}
+	if req, ok := c.next(); ok {
+		t.Fatalf("expected no request while opens fill the limit, got %+v", req)
+	}
+
+	c.forget(1)
+	if req, ok := c.next(); !ok || req.ReqType != uint8(model.RequestTypeOpen) {
+		t.Fatalf("expected open once an open is forgotten, got %+v", req)
+	}
+	c.answered(proxy.OrderResponse{ID: 2})
+	if req, ok := c.next(); !ok || req.ReqType != uint8(model.RequestTypeClose) || req.ID != 4 {
+		t.Fatalf("expected close of the answered open, got %+v", req)
+	}
+}
+
+func TestLatencyStats(t *testing.T) {
+	var latencies []time.Duration
+	for i := 100; i > 0; i-- {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	got := latencyStats(latencies)
+	want := LatencyStats{Count: 100, P50: 50, P90: 90, P99: 99, Max: 100}
+	if got != want {
+		t.Fatalf("expected: %+v, got: %+v", want, got)
+	}
+	if empty := latencyStats(nil); empty != (LatencyStats{}) {
+		t.Fatalf("expected empty stats, got: %+v", empty)
+	}
+}
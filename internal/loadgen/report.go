@@ -0,0 +1,122 @@
+package loadgen
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Report is the summary of a load test
+type Report struct {
+	Clients         int                     `json:"clients"`
+	Instruments     int                     `json:"instruments"`
+	DurationSeconds float64                 `json:"durationSeconds"`
+	Sent            int                     `json:"sent"`
+	Received        int                     `json:"received"`
+	Unanswered      int                     `json:"unanswered"`
+	SendErrors      int                     `json:"sendErrors"`
+	Throughput      float64                 `json:"throughputPerSecond"`
+	Codes           map[string]LatencyStats `json:"codes"`
+}
+
+// LatencyStats are latency percentiles in milliseconds of responses with the same result code
+type LatencyStats struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50Ms"`
+	P90   float64 `json:"p90Ms"`
+	P99   float64 `json:"p99Ms"`
+	Max   float64 `json:"maxMs"`
+}
+
+// collector gathers results of all clients
+type collector struct {
+	sync.Mutex
+	sent       int
+	sendErrors int
+	unanswered int
+	latencies  map[uint16][]time.Duration
+}
+
+func newCollector() *collector {
+	return &collector{
+		latencies: make(map[uint16][]time.Duration),
+	}
+}
+
+func (c *collector) addSent() {
+	c.Lock()
+	defer c.Unlock()
+	c.sent++
+}
+
+func (c *collector) addSendError() {
+	c.Lock()
+	defer c.Unlock()
+	c.sendErrors++
+}
+
+func (c *collector) addUnanswered(n int) {
+	c.Lock()
+	defer c.Unlock()
+	c.unanswered += n
+}
+
+func (c *collector) addResponse(code uint16, latency time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.latencies[code] = append(c.latencies[code], latency)
+}
+
+func (c *collector) report(cfg Config, elapsed time.Duration) Report {
+	c.Lock()
+	defer c.Unlock()
+
+	r := Report{
+		Clients:         cfg.Clients,
+		Instruments:     len(cfg.Instruments),
+		DurationSeconds: elapsed.Seconds(),
+		Sent:            c.sent,
+		Unanswered:      c.unanswered,
+		SendErrors:      c.sendErrors,
+		Codes:           make(map[string]LatencyStats),
+	}
+	for code, latencies := range c.latencies {
+		r.Received += len(latencies)
+		r.Codes[strconv.Itoa(int(code))] = latencyStats(latencies)
+	}
+	if elapsed > 0 {
+		r.Throughput = float64(r.Received) / elapsed.Seconds()
+	}
+	return r
+}
+
+func latencyStats(latencies []time.Duration) LatencyStats {
+	sorted := make([]time.Duration, len(latencies))
+	copy(sorted, latencies)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return LatencyStats{
+		Count: len(sorted),
+		P50:   milliseconds(percentile(sorted, 50)),
+		P90:   milliseconds(percentile(sorted, 90)),
+		P99:   milliseconds(percentile(sorted, 99)),
+		Max:   milliseconds(percentile(sorted, 100)),
+	}
+}
+
+// percentile returns nearest-rank percentile of sorted durations
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,39 @@
+// Package metrics holds the counters of the proxy, published with expvar
+package metrics
+
+import (
+	"expvar"
+	"net/http"
+)
+
+var (
+	// InstrumentLimitRejections counts orders rejected by firm-wide limits
+	// per instrument
+	InstrumentLimitRejections = expvar.NewMap("instrument_limit_rejections")
+	// ShadowRejections counts orders the shadow rules would reject per reason
+	ShadowRejections = expvar.NewMap("shadow_rejections")
+	// BackendsHealthy is 1 for healthy order server backends and 0 for the rest
+	BackendsHealthy = expvar.NewMap("backends_healthy")
+	// BreakerStates is the state of the circuit breaker per route:
+	// 0 closed, 1 open, 2 half-open
+	BreakerStates = expvar.NewMap("breaker_states")
+	// BreakerRejections counts requests failed by open breakers per route
+	BreakerRejections = expvar.NewMap("breaker_rejections")
+	// Sessions is the number of client sessions admitted by the listener
+	Sessions = expvar.NewInt("sessions")
+	// RejectedConnections counts connections rejected by admission control
+	// per reason: sessions, ip or rate
+	RejectedConnections = expvar.NewMap("rejected_connections")
+)
+
+// Set sets the value of the key in the map
+func Set(m *expvar.Map, key string, value int64) {
+	v := new(expvar.Int)
+	v.Set(value)
+	m.Set(key, v)
+}
+
+// Handler serves all published metrics as JSON
+func Handler() http.Handler {
+	return expvar.Handler()
+}
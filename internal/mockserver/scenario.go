@@ -0,0 +1,148 @@
+package mockserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"time"
+)
+
+// Latency distributions supported by the mock order server
+const (
+	DistributionFixed       = "fixed"
+	DistributionUniform     = "uniform"
+	DistributionNormal      = "normal"
+	DistributionExponential = "exponential"
+)
+
+// Scenario describes how the mock order server answers requests
+type Scenario struct {
+	// Default is used for instruments not listed in Instruments
+	Default     Behavior            `json:"default"`
+	Instruments map[string]Behavior `json:"instruments"`
+	// DisconnectAfter closes the connection right after the N-th received
+	// message, which is left unanswered. Zero keeps connections open.
+	DisconnectAfter int `json:"disconnectAfter"`
+	// ReorderWindow holds responses until that many are collected and then
+	// sends them in reverse order. Zero or one keeps the original order.
+	ReorderWindow int `json:"reorderWindow"`
+	// Seed makes random decisions reproducible, zero seeds from the clock
+	Seed int64 `json:"seed"`
+}
+
+// Behavior is the per-instrument behaviour of the mock order server
+type Behavior struct {
+	// RejectRate is a probability in [0, 1] to answer with RejectCode
+	RejectRate float64 `json:"rejectRate"`
+	RejectCode uint16  `json:"rejectCode"`
+	// DropRate is a probability in [0, 1] to never answer a request
+	DropRate float64 `json:"dropRate"`
+	Latency  Latency `json:"latency"`
+}
+
+// Latency describes a distribution of response delays
+type Latency struct {
+	Distribution string `json:"distribution"`
+	// Min and Max bound uniform distribution
+	Min Duration `json:"min"`
+	Max Duration `json:"max"`
+	// Mean is the value of fixed distribution and the mean of normal
+	// and exponential ones
+	Mean   Duration `json:"mean"`
+	StdDev Duration `json:"stdDev"`
+}
+
+// Duration is a time.Duration read from strings like "150ms"
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// LoadScenario reads a scenario from a JSON file
+func LoadScenario(path string) (Scenario, error) {
+	var sc Scenario
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return sc, err
+	}
+	if err := json.Unmarshal(b, &sc); err != nil {
+		return sc, fmt.Errorf("parse scenario %s: %w", path, err)
+	}
+	return sc, sc.Validate()
+}
+
+// Validate checks that rates are probabilities and distributions are known
+func (sc Scenario) Validate() error {
+	if err := sc.Default.validate(); err != nil {
+		return fmt.Errorf("default: %w", err)
+	}
+	for instrument, b := range sc.Instruments {
+		if err := b.validate(); err != nil {
+			return fmt.Errorf("instrument %s: %w", instrument, err)
+		}
+	}
+	if sc.DisconnectAfter < 0 || sc.ReorderWindow < 0 {
+		return fmt.Errorf("disconnectAfter and reorderWindow can't be negative")
+	}
+	return nil
+}
+
+func (sc Scenario) behavior(instrument string) Behavior {
+	if b, ok := sc.Instruments[instrument]; ok {
+		return b
+	}
+	return sc.Default
+}
+
+func (b Behavior) validate() error {
+	if b.RejectRate < 0 || b.RejectRate > 1 {
+		return fmt.Errorf("reject rate %f is not a probability", b.RejectRate)
+	}
+	if b.DropRate < 0 || b.DropRate > 1 {
+		return fmt.Errorf("drop rate %f is not a probability", b.DropRate)
+	}
+	switch b.Latency.Distribution {
+	case "", DistributionFixed, DistributionUniform, DistributionNormal, DistributionExponential:
+		return nil
+	default:
+		return fmt.Errorf("unknown latency distribution %q", b.Latency.Distribution)
+	}
+}
+
+// sample returns a random delay according to the distribution
+func (l Latency) sample(rnd *rand.Rand) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case DistributionUniform:
+		if l.Max > l.Min {
+			d = time.Duration(l.Min) + time.Duration(rnd.Int63n(int64(l.Max-l.Min)))
+		} else {
+			d = time.Duration(l.Min)
+		}
+	case DistributionNormal:
+		d = time.Duration(rnd.NormFloat64()*float64(l.StdDev) + float64(l.Mean))
+	case DistributionExponential:
+		d = time.Duration(rnd.ExpFloat64() * float64(l.Mean))
+	default:
+		d = time.Duration(l.Mean)
+	}
+	if d < 0 {
+		return 0
+	}
+	return d
+}
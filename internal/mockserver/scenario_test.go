@@ -0,0 +1,95 @@
+package mockserver
+
+import (
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadScenario(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scenario")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "scenario.json")
+	content := `{
+		"default": {"latency": {"distribution": "uniform", "min": "1ms", "max": "5ms"}},
+		"instruments": {"USDRUB": {"rejectRate": 0.5, "rejectCode": 3}},
+		"disconnectAfter": 10
+	}`
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := LoadScenario(path)
+	if err != nil {
+		t.Fatalf("load scenario: %v", err)
+	}
+	if sc.Default.Latency.Max != Duration(5*time.Millisecond) {
+		t.Fatalf("expected max latency 5ms, got %v", time.Duration(sc.Default.Latency.Max))
+	}
+	if b := sc.behavior("USDRUB"); b.RejectRate != 0.5 || b.RejectCode != 3 {
+		t.Fatalf("unexpected USDRUB behavior: %+v", b)
+	}
+	if sc.DisconnectAfter != 10 {
+		t.Fatalf("expected disconnect after 10, got %d", sc.DisconnectAfter)
+	}
+}
+
+func TestScenarioValidate(t *testing.T) {
+	cases := []struct {
+		name     string
+		scenario Scenario
+		wantErr  bool
+	}{
+		{
+			name: "valid",
+			scenario: Scenario{
+				Default: Behavior{RejectRate: 1, Latency: Latency{Distribution: DistributionNormal}},
+			},
+		},
+		{
+			name:     "reject rate above one",
+			scenario: Scenario{Default: Behavior{RejectRate: 1.5}},
+			wantErr:  true,
+		},
+		{
+			name: "negative drop rate",
+			scenario: Scenario{
+				Instruments: map[string]Behavior{"EURUSD": {DropRate: -1}},
+			},
+			wantErr: true,
+		},
+		{
+			name:     "unknown distribution",
+			scenario: Scenario{Default: Behavior{Latency: Latency{Distribution: "pareto"}}},
+			wantErr:  true,
+		},
+	}
+	for _, tc := range cases {
+		err := tc.scenario.Validate()
+		if (err != nil) != tc.wantErr {
+			t.Fatalf("%s failed: expected error: %t, got: %v", tc.name, tc.wantErr, err)
+		}
+	}
+}
+
+func TestLatencySample(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	l := Latency{
+		Distribution: DistributionUniform,
+		Min:          Duration(10 * time.Millisecond),
+		Max:          Duration(20 * time.Millisecond),
+	}
+	for i := 0; i < 1000; i++ {
+		d := l.sample(rnd)
+		if d < 10*time.Millisecond || d >= 20*time.Millisecond {
+			t.Fatalf("sample %v out of [10ms, 20ms)", d)
+		}
+	}
+}
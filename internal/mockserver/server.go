@@ -0,0 +1,217 @@
+package mockserver
+
+import (
+	"encoding/binary"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+)
+
+// Server is a mock order server answering according to a scenario
+type Server struct {
+	received uint64
+	scenario Scenario
+	upgrader websocket.Upgrader
+	rndMu    sync.Mutex
+	rnd      *rand.Rand
+}
+
+func New(sc Scenario) *Server {
+	seed := sc.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Server{
+		scenario: sc,
+		upgrader: websocket.Upgrader{
+			Subprotocols: []string{proxy.SubprotocolBatch},
+		},
+		rnd: rand.New(rand.NewSource(seed)),
+	}
+}
+
+// NewTestServer starts an in-process mock order server,
+// the caller should Close it when finished
+func NewTestServer(sc Scenario) *httptest.Server {
+	return httptest.NewServer(New(sc))
+}
+
+// Received returns the number of requests received over all connections
+func (s *Server) Received() int {
+	return int(atomic.LoadUint64(&s.received))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ws, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Print("upgrade:", err)
+		return
+	}
+	c := &conn{ws: ws, reorderWindow: s.scenario.ReorderWindow}
+	defer ws.Close()
+	batch := ws.Subprotocol() == proxy.SubprotocolBatch
+
+	for n := 1; ; n++ {
+		mt, message, err := ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		var reqs []proxy.OrderRequest
+		if batch {
+			if reqs, err = proxy.DecodeOrderRequestBatch(message); err != nil {
+				log.Println("decode batch:", err)
+				continue
+			}
+		} else {
+			var req proxy.OrderRequest
+			if err := proxy.DecodeOrderRequestInto(message, &req); err != nil {
+				log.Println("decode request:", err)
+				s.answerInvalid(c, mt, message)
+				continue
+			}
+			reqs = []proxy.OrderRequest{req}
+		}
+		atomic.AddUint64(&s.received, uint64(len(reqs)))
+		log.Printf("recv: %v", reqs)
+
+		if s.scenario.DisconnectAfter > 0 && n >= s.scenario.DisconnectAfter {
+			log.Printf("disconnecting after %d messages", n)
+			return
+		}
+		if batch {
+			s.answerBatch(c, mt, reqs)
+			continue
+		}
+		s.answer(c, mt, reqs[0])
+	}
+}
+
+// codeInvalid answers requests the server can't decode
+const codeInvalid = 3
+
+// answerInvalid rejects the malformed request if it's long enough
+// to hold the client and request IDs, there's no ID to answer to otherwise
+func (s *Server) answerInvalid(c *conn, mt int, message []byte) {
+	if len(message) < 8 {
+		return
+	}
+	c.respond(mt, proxy.OrderResponse{ID: binary.LittleEndian.Uint32(message[4:8]), Code: codeInvalid})
+}
+
+// decide returns the response to the request, its delay and
+// whether the request should be left unanswered
+func (s *Server) decide(req proxy.OrderRequest) (proxy.OrderResponse, time.Duration, bool) {
+	b := s.scenario.behavior(req.Instrument)
+
+	s.rndMu.Lock()
+	drop := s.rnd.Float64() < b.DropRate
+	reject := s.rnd.Float64() < b.RejectRate
+	delay := b.Latency.sample(s.rnd)
+	s.rndMu.Unlock()
+
+	if drop {
+		log.Printf("dropped: %d", req.ID)
+	}
+	res := proxy.OrderResponse{
+		ID:   req.ID,
+		Code: 0,
+	}
+	if reject {
+		res.Code = b.RejectCode
+	}
+	return res, delay, drop
+}
+
+func (s *Server) answer(c *conn, mt int, req proxy.OrderRequest) {
+	res, delay, drop := s.decide(req)
+	if drop {
+		return
+	}
+	if delay == 0 {
+		c.respond(mt, res)
+		return
+	}
+	time.AfterFunc(delay, func() {
+		c.respond(mt, res)
+	})
+}
+
+// answerBatch answers all requests of a batch in a single frame
+// after the longest of their delays
+func (s *Server) answerBatch(c *conn, mt int, reqs []proxy.OrderRequest) {
+	var resps []proxy.OrderResponse
+	var maxDelay time.Duration
+	for _, req := range reqs {
+		res, delay, drop := s.decide(req)
+		if drop {
+			continue
+		}
+		resps = append(resps, res)
+		if delay > maxDelay {
+			maxDelay = delay
+		}
+	}
+	if len(resps) == 0 {
+		return
+	}
+	time.AfterFunc(maxDelay, func() {
+		c.respondBatch(mt, resps)
+	})
+}
+
+// conn serializes writes to a single connection
+type conn struct {
+	sync.Mutex
+	ws            *websocket.Conn
+	reorderWindow int
+	held          []proxy.OrderResponse
+}
+
+func (c *conn) respond(mt int, res proxy.OrderResponse) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.reorderWindow < 2 {
+		c.write(mt, res)
+		return
+	}
+	c.held = append(c.held, res)
+	if len(c.held) < c.reorderWindow {
+		return
+	}
+	for i := len(c.held) - 1; i >= 0; i-- {
+		c.write(mt, c.held[i])
+	}
+	c.held = c.held[:0]
+}
+
+func (c *conn) respondBatch(mt int, resps []proxy.OrderResponse) {
+	c.Lock()
+	defer c.Unlock()
+
+	message, err := proxy.EncodeOrderResponseBatch(resps)
+	if err != nil {
+		log.Println("encode batch:", err)
+		return
+	}
+	if err := c.ws.WriteMessage(mt, message); err != nil {
+		log.Println("write:", err)
+		return
+	}
+	log.Printf("sent: %v", resps)
+}
+
+func (c *conn) write(mt int, res proxy.OrderResponse) {
+	if err := c.ws.WriteMessage(mt, proxy.EncodeOrderResponse(res)); err != nil {
+		log.Println("write:", err)
+		return
+	}
+	log.Printf("sent: %v", res)
+}
@@ -0,0 +1,234 @@
+package mockserver
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	proxy "test.task/backend/proxy"
+)
+
+func TestServerResponses(t *testing.T) {
+	cases := []struct {
+		name     string
+		scenario Scenario
+		requests []proxy.OrderRequest
+		want     []proxy.OrderResponse
+	}{
+		{
+			name:     "default success",
+			requests: []proxy.OrderRequest{{ID: 1, Instrument: "EURUSD"}},
+			want:     []proxy.OrderResponse{{ID: 1, Code: 0}},
+		},
+		{
+			name: "instrument rejected",
+			scenario: Scenario{
+				Instruments: map[string]Behavior{
+					"USDRUB": {RejectRate: 1, RejectCode: 3},
+				},
+			},
+			requests: []proxy.OrderRequest{
+				{ID: 1, Instrument: "USDRUB"},
+				{ID: 2, Instrument: "EURUSD"},
+			},
+			want: []proxy.OrderResponse{
+				{ID: 1, Code: 3},
+				{ID: 2, Code: 0},
+			},
+		},
+		{
+			name: "dropped responses",
+			scenario: Scenario{
+				Instruments: map[string]Behavior{
+					"USDRUB": {DropRate: 1},
+				},
+			},
+			requests: []proxy.OrderRequest{
+				{ID: 1, Instrument: "USDRUB"},
+				{ID: 2, Instrument: "EURUSD"},
+			},
+			want: []proxy.OrderResponse{{ID: 2, Code: 0}},
+		},
+		{
+			name:     "out of order responses",
+			scenario: Scenario{ReorderWindow: 3},
+			requests: []proxy.OrderRequest{
+				{ID: 1, Instrument: "EURUSD"},
+				{ID: 2, Instrument: "EURUSD"},
+				{ID: 3, Instrument: "EURUSD"},
+			},
+			want: []proxy.OrderResponse{
+				{ID: 3, Code: 0},
+				{ID: 2, Code: 0},
+				{ID: 1, Code: 0},
+			},
+		},
+		{
+			name: "slow instrument answered last",
+			scenario: Scenario{
+				Instruments: map[string]Behavior{
+					"USDRUB": {Latency: Latency{Mean: Duration(50 * time.Millisecond)}},
+				},
+			},
+			requests: []proxy.OrderRequest{
+				{ID: 1, Instrument: "USDRUB"},
+				{ID: 2, Instrument: "EURUSD"},
+			},
+			want: []proxy.OrderResponse{
+				{ID: 2, Code: 0},
+				{ID: 1, Code: 0},
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := NewTestServer(tc.scenario)
+			defer s.Close()
+			ws := dial(t, s.URL)
+			defer ws.Close()
+
+			for _, req := range tc.requests {
+				send(t, ws, req)
+			}
+			got := receiveAll(ws, 200*time.Millisecond)
+			if len(got) != len(tc.want) {
+				t.Fatalf("Expected %+v, got %+v", tc.want, got)
+			}
+			for i := range tc.want {
+				if got[i] != tc.want[i] {
+					t.Fatalf("Expected %+v, got %+v", tc.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestServerDisconnectAfter(t *testing.T) {
+	s := NewTestServer(Scenario{DisconnectAfter: 2})
+	defer s.Close()
+	ws := dial(t, s.URL)
+	defer ws.Close()
+
+	send(t, ws, proxy.OrderRequest{ID: 1, Instrument: "EURUSD"})
+	send(t, ws, proxy.OrderRequest{ID: 2, Instrument: "EURUSD"})
+
+	_, m, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatalf("expected response to the first request, got: %v", err)
+	}
+	if res := proxy.DecodeOrderResponse(m); res.ID != 1 {
+		t.Fatalf("expected response to request 1, got %+v", res)
+	}
+	ws.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := ws.ReadMessage(); !websocket.IsUnexpectedCloseError(err) {
+		t.Fatalf("expected connection to be closed, got: %v", err)
+	}
+}
+
+func TestServerMalformedRequest(t *testing.T) {
+	s := NewTestServer(Scenario{})
+	defer s.Close()
+	ws := dial(t, s.URL)
+	defer ws.Close()
+
+	valid := proxy.EncodeOrderRequest(proxy.OrderRequest{ClientID: 1, ID: 7, Instrument: "EURUSD"})
+	for _, m := range [][]byte{{1, 2, 3}, valid[:10]} {
+		if err := ws.WriteMessage(websocket.BinaryMessage, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	send(t, ws, proxy.OrderRequest{ID: 8, Instrument: "EURUSD"})
+
+	// the frame without ID is skipped and the connection stays open
+	got := receiveAll(ws, 200*time.Millisecond)
+	want := []proxy.OrderResponse{{ID: 7, Code: codeInvalid}, {ID: 8, Code: 0}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestServerBatch(t *testing.T) {
+	s := NewTestServer(Scenario{
+		Instruments: map[string]Behavior{
+			"USDRUB": {RejectRate: 1, RejectCode: 3},
+			"XLMEUR": {DropRate: 1},
+		},
+	})
+	defer s.Close()
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Scheme = "ws"
+	dialer := websocket.Dialer{Subprotocols: []string{proxy.SubprotocolBatch}}
+	ws, _, err := dialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+
+	batch, err := proxy.EncodeOrderRequestBatch([]proxy.OrderRequest{
+		{ID: 1, Instrument: "EURUSD"},
+		{ID: 2, Instrument: "USDRUB"},
+		{ID: 3, Instrument: "XLMEUR"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteMessage(websocket.BinaryMessage, batch); err != nil {
+		t.Fatal(err)
+	}
+
+	_, m, err := ws.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := proxy.DecodeOrderResponseBatch(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []proxy.OrderResponse{{ID: 1, Code: 0}, {ID: 2, Code: 3}}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func dial(t *testing.T, serverURL string) *websocket.Conn {
+	t.Helper()
+
+	u, err := url.Parse(serverURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.Scheme = "ws"
+	u.Path = "/connect"
+	ws, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ws
+}
+
+func send(t *testing.T, ws *websocket.Conn, req proxy.OrderRequest) {
+	t.Helper()
+
+	if err := ws.WriteMessage(websocket.BinaryMessage, proxy.EncodeOrderRequest(req)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// receiveAll reads responses until nothing arrives for the given time
+func receiveAll(ws *websocket.Conn, idle time.Duration) []proxy.OrderResponse {
+	var res []proxy.OrderResponse
+	for {
+		ws.SetReadDeadline(time.Now().Add(idle))
+		_, m, err := ws.ReadMessage()
+		if err != nil {
+			return res
+		}
+		res = append(res, proxy.DecodeOrderResponse(m))
+	}
+}
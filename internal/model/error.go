@@ -10,4 +10,26 @@ var (
 	ErrVolumeSumExceedes Error = errors.New("sum volumes of orders exceeds")
 	ErrNoOrderToClose    Error = errors.New("no order to close")
 	ErrNegativeVolumeSum Error = errors.New("negative volume sum violation")
+	ErrBatchRejected     Error = errors.New("batch rejected")
+
+	ErrNetExposureExceedes   Error = errors.New("net exposure exceeds")
+	ErrGrossExposureExceedes Error = errors.New("gross exposure exceeds")
+
+	ErrClientOrdersExceedes     Error = errors.New("number of open orders of client exceeds")
+	ErrClientVolumeExceedes     Error = errors.New("sum volumes of orders of client exceeds")
+	ErrCurrencyExposureExceedes Error = errors.New("currency exposure exceeds")
+
+	ErrInstrumentLimitExceedes Error = errors.New("firm-wide instrument limit exceeds")
+	ErrOrderVolumeExceedes     Error = errors.New("volume of order exceeds")
+	ErrInstrumentBlacklisted   Error = errors.New("instrument is blacklisted")
+	ErrWindowVolumeExceedes    Error = errors.New("volume opened in time window exceeds")
+
+	ErrInstrumentHalted    Error = errors.New("instrument is halted")
+	ErrOutsideTradingHours Error = errors.New("outside trading hours")
+
+	ErrUpstreamTimeout     Error = errors.New("order server didn't respond in time")
+	ErrUpstreamUnavailable Error = errors.New("order server unavailable")
+	ErrTooManyInflight     Error = errors.New("too many requests in flight")
+
+	ErrClientBlocked Error = errors.New("client is blocked")
 )
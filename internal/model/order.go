@@ -21,6 +21,26 @@ const (
 	ResultCodeOpenOrdersExceedes
 	ResultCodeVolumesExceedes
 	ResultCodeOther
+	// codes below are sent only to clients of protocol version 2 and above,
+	// older clients get ResultCodeOther instead
+	ResultCodeInvalidRequest
+	ResultCodeNoOrderToClose
+	ResultCodeBatchRejected
+	ResultCodeNetExposureExceedes
+	ResultCodeGrossExposureExceedes
+	ResultCodeClientOrdersExceedes
+	ResultCodeClientVolumeExceedes
+	ResultCodeCurrencyExposureExceedes
+	ResultCodeInstrumentLimitExceedes
+	ResultCodeOrderVolumeExceedes
+	ResultCodeInstrumentBlacklisted
+	ResultCodeWindowVolumeExceedes
+	ResultCodeInstrumentHalted
+	ResultCodeOutsideTradingHours
+	ResultCodeUpstreamTimeout
+	ResultCodeUpstreamUnavailable
+	ResultCodeTooManyInflight
+	ResultCodeClientBlocked
 )
 
 // OrderRequest is the request from client to server
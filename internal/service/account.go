@@ -0,0 +1,66 @@
+package service
+
+import (
+	"test.task/backend/proxy/internal/model"
+)
+
+// account is the position of a client over all instruments
+type account struct {
+	count     uint
+	volumeSum float64
+	// currencies holds the volume of open orders per currency
+	// of the instruments they are opened on
+	currencies map[string]float64
+}
+
+func newAccount() *account {
+	return &account{currencies: make(map[string]float64)}
+}
+
+func (acc *account) snapshot() Account {
+	return Account{
+		Count:      acc.count,
+		VolumeSum:  acc.volumeSum,
+		Currencies: acc.currencies,
+	}
+}
+
+// apply adds the order to the account, a close order is removed from it
+func (acc *account) apply(order model.OrderRequest) {
+	volume := order.Volume
+	if order.ReqType == model.RequestTypeClose {
+		acc.count--
+		volume = -volume
+	} else {
+		acc.count++
+	}
+	acc.volumeSum += volume
+	for _, currency := range currencies(order.Instrument) {
+		acc.currencies[currency] += volume
+	}
+}
+
+// currencies returns base and quote currencies of the instrument
+// named like USDRUB, other instruments have none
+func currencies(instrument string) []string {
+	if len(instrument) != 6 {
+		return nil
+	}
+	for _, c := range instrument {
+		if c < 'A' || c > 'Z' {
+			return nil
+		}
+	}
+	return []string{instrument[:3], instrument[3:]}
+}
+
+// reversed returns the order undoing the given one
+func reversed(order model.OrderRequest) model.OrderRequest {
+	switch order.ReqType {
+	case model.RequestTypeOpen:
+		order.ReqType = model.RequestTypeClose
+	case model.RequestTypeClose:
+		order.ReqType = model.RequestTypeOpen
+	}
+	return order
+}
@@ -1,18 +1,48 @@
 package service
 
 import (
+	"log"
 	"sync"
 )
 
 type clientsService struct {
 	sync.Mutex
 	connectedClients map[uint32]struct{}
+	// blocked holds clients blocked by the kill switch,
+	// saved to blocksPath on every change if it's set
+	blocked    map[uint32]BlockMode
+	blocksPath string
+	// kicks are closed once connected clients are blocked entirely
+	kicks map[uint32]*kick
 }
 
-func NewClientsService() *clientsService {
-	return &clientsService{
+// ClientsOption configures optional behaviour of the clients service
+type ClientsOption func(*clientsService)
+
+// WithBlocks starts the kill switch with the blocked clients, changes are
+// saved to the file at path so they survive restarts, unless it's empty
+func WithBlocks(path string, blocks map[uint32]BlockMode) ClientsOption {
+	return func(svc *clientsService) {
+		svc.blocksPath = path
+		for clientID, mode := range blocks {
+			svc.blocked[clientID] = mode
+		}
+	}
+}
+
+func NewClientsService(opts ...ClientsOption) *clientsService {
+	svc := &clientsService{
 		connectedClients: make(map[uint32]struct{}),
+		blocked:          make(map[uint32]BlockMode),
+		kicks:            make(map[uint32]*kick),
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	if len(svc.blocked) > 0 {
+		log.Printf("clients service started. blocked clients: %d", len(svc.blocked))
 	}
+	return svc
 }
 
 // TryConnectClient tries to connect a new client
@@ -0,0 +1,169 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+// BlockMode is the way the kill switch blocks a client
+type BlockMode string
+
+const (
+	// BlockOpens rejects new open orders of the client, closes are still allowed
+	BlockOpens BlockMode = "opens"
+	// BlockAll rejects all orders of the client and disconnects it
+	BlockAll BlockMode = "all"
+)
+
+// Valid tells whether the mode is known
+func (m BlockMode) Valid() bool {
+	return m == BlockOpens || m == BlockAll
+}
+
+// LoadBlocks reads blocked clients from a JSON file like {"4815": "opens"},
+// there are no blocked clients if the file doesn't exist yet
+func LoadBlocks(path string) (map[uint32]BlockMode, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var res map[uint32]BlockMode
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("decode blocks: %w", err)
+	}
+	for clientID, mode := range res {
+		if !mode.Valid() {
+			return nil, fmt.Errorf("invalid block mode %q of client %d", mode, clientID)
+		}
+	}
+	return res, nil
+}
+
+// Block blocks the client, clients blocked entirely are disconnected
+func (svc *clientsService) Block(clientID uint32, mode BlockMode) error {
+	if !mode.Valid() {
+		return fmt.Errorf("invalid block mode %q", mode)
+	}
+	svc.Lock()
+	defer svc.Unlock()
+	prev, wasBlocked := svc.blocked[clientID]
+	svc.blocked[clientID] = mode
+	if err := svc.saveBlocks(); err != nil {
+		if wasBlocked {
+			svc.blocked[clientID] = prev
+		} else {
+			delete(svc.blocked, clientID)
+		}
+		return err
+	}
+	if k, ok := svc.kicks[clientID]; ok && mode == BlockAll {
+		close(k.ch)
+		delete(svc.kicks, clientID)
+	}
+	log.Printf("client %d blocked: %s", clientID, mode)
+	return nil
+}
+
+// Unblock lifts the block of the client
+func (svc *clientsService) Unblock(clientID uint32) error {
+	svc.Lock()
+	defer svc.Unlock()
+	prev, ok := svc.blocked[clientID]
+	if !ok {
+		return nil
+	}
+	delete(svc.blocked, clientID)
+	if err := svc.saveBlocks(); err != nil {
+		svc.blocked[clientID] = prev
+		return err
+	}
+	log.Printf("client %d unblocked", clientID)
+	return nil
+}
+
+// Blocks returns the blocked clients
+func (svc *clientsService) Blocks() map[uint32]BlockMode {
+	svc.Lock()
+	defer svc.Unlock()
+	res := make(map[uint32]BlockMode, len(svc.blocked))
+	for clientID, mode := range svc.blocked {
+		res[clientID] = mode
+	}
+	return res
+}
+
+// kick is closed once the client is blocked entirely,
+// it's shared by sessions of the client
+type kick struct {
+	ch   chan struct{}
+	refs int
+}
+
+// Kicked returns a channel closed once the client is blocked entirely,
+// it's closed already if the client is blocked. The release func must be
+// called once the session is over, so the channel isn't kept.
+func (svc *clientsService) Kicked(clientID uint32) (<-chan struct{}, func()) {
+	svc.Lock()
+	defer svc.Unlock()
+	if svc.blocked[clientID] == BlockAll {
+		ch := make(chan struct{})
+		close(ch)
+		return ch, func() {}
+	}
+	k, ok := svc.kicks[clientID]
+	if !ok {
+		k = &kick{ch: make(chan struct{})}
+		svc.kicks[clientID] = k
+	}
+	k.refs++
+	var once sync.Once
+	return k.ch, func() { once.Do(func() { svc.release(clientID, k) }) }
+}
+
+// release drops the kick of a session once no session of the client holds it
+func (svc *clientsService) release(clientID uint32, k *kick) {
+	svc.Lock()
+	defer svc.Unlock()
+	if k.refs--; k.refs == 0 && svc.kicks[clientID] == k {
+		delete(svc.kicks, clientID)
+	}
+}
+
+// Check makes the kill switch a rule of the orders service
+func (svc *clientsService) Check(order model.OrderRequest, _ State) error {
+	svc.Lock()
+	mode := svc.blocked[order.ClientID]
+	svc.Unlock()
+	if mode == BlockAll || mode == BlockOpens && order.ReqType == model.RequestTypeOpen {
+		return model.ErrClientBlocked
+	}
+	return nil
+}
+
+// saveBlocks writes the blocked clients to the file, replacing it at once
+func (svc *clientsService) saveBlocks() error {
+	if svc.blocksPath == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(svc.blocked, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := svc.blocksPath + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("save blocks: %w", err)
+	}
+	if err := os.Rename(tmp, svc.blocksPath); err != nil {
+		return fmt.Errorf("save blocks: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package service
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+func TestKillSwitch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "blocks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "blocks.json")
+
+	blocks, err := LoadBlocks(path)
+	if err != nil {
+		t.Fatalf("expected missing file to be no blocks, got: %v", err)
+	}
+	svc := NewClientsService(WithBlocks(path, blocks))
+	if !svc.TryConnectClient(1) {
+		t.Fatal("expected client to connect")
+	}
+	kicked, release := svc.Kicked(1)
+	defer release()
+
+	if err := svc.Block(1, BlockOpens); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Block(2, BlockAll); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Block(3, "some"); err == nil {
+		t.Fatal("expected invalid mode to fail")
+	}
+	select {
+	case <-kicked:
+		t.Fatal("expected client blocking opens to stay connected")
+	default:
+	}
+
+	order := func(clientID uint32, reqType model.RequestType) model.OrderRequest {
+		return model.OrderRequest{ClientID: clientID, ReqType: reqType, OrderKind: model.OrderKindBuy, Volume: 100, Instrument: "USDRUB"}
+	}
+	cases := []struct {
+		order   model.OrderRequest
+		wantErr error
+	}{
+		{order(1, model.RequestTypeOpen), model.ErrClientBlocked},
+		{order(1, model.RequestTypeClose), nil},
+		{order(2, model.RequestTypeClose), model.ErrClientBlocked},
+		{order(3, model.RequestTypeOpen), nil},
+	}
+	for _, tc := range cases {
+		if err := svc.Check(tc.order, State{}); !errors.Is(err, tc.wantErr) {
+			t.Fatalf("client %d type %d: expected %v, got %v", tc.order.ClientID, tc.order.ReqType, tc.wantErr, err)
+		}
+	}
+
+	if err := svc.Block(1, BlockAll); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-kicked:
+	default:
+		t.Fatal("expected client blocked entirely to be kicked")
+	}
+	if err := svc.Unblock(2); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks survive restarts
+	blocks, err = LoadBlocks(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(blocks) != 1 || blocks[1] != BlockAll {
+		t.Fatalf("expected saved blocks {1: all}, got: %v", blocks)
+	}
+	kicked, _ = NewClientsService(WithBlocks(path, blocks)).Kicked(1)
+	select {
+	case <-kicked:
+	default:
+		t.Fatal("expected blocked client to be kicked right away after restart")
+	}
+}
+
+func TestKickedRelease(t *testing.T) {
+	svc := NewClientsService()
+	kicked, release := svc.Kicked(1)
+	other, releaseOther := svc.Kicked(1)
+	if kicked != other {
+		t.Fatal("expected sessions of the client to share the channel")
+	}
+	release()
+	release()
+	if len(svc.kicks) != 1 {
+		t.Fatal("expected channel to be kept while a session holds it")
+	}
+	releaseOther()
+	if len(svc.kicks) != 0 {
+		t.Fatalf("expected channel to be dropped once sessions are over, got %d", len(svc.kicks))
+	}
+}
@@ -1,39 +1,201 @@
 package service
 
 import (
+	"errors"
+	"sort"
 	"sync"
 
 	"log"
 
+	"test.task/backend/proxy/internal/metrics"
 	"test.task/backend/proxy/internal/model"
 )
 
 type instrument struct {
-	count     uint
-	volumeSum float64
+	count      uint
+	volumeSum  float64
+	buyVolume  float64
+	sellVolume float64
+}
+
+func (instr instrument) position() Position {
+	return Position{
+		Count:      instr.count,
+		VolumeSum:  instr.volumeSum,
+		BuyVolume:  instr.buyVolume,
+		SellVolume: instr.sellVolume,
+	}
+}
+
+// apply adds the order to the instrument, a close order is removed from it
+func (instr *instrument) apply(order model.OrderRequest) {
+	volume := order.Volume
+	if order.ReqType == model.RequestTypeClose {
+		instr.count--
+		volume = -volume
+	} else {
+		instr.count++
+	}
+	instr.volumeSum += volume
+	switch order.OrderKind {
+	case model.OrderKindBuy:
+		instr.buyVolume += volume
+	case model.OrderKindSell:
+		instr.sellVolume += volume
+	}
 }
 
 type ordersService struct {
 	// I've decided to use map + mutex instead of syncmap because there are
 	// gonna be constant key manipulations we can have many clients
 	sync.Mutex
-	ordersLimit        uint
-	volumeSumLimit     float64
+	// rules are checked in order, the first rejection wins
+	rules []Rule
+	// shadowRules never reject, orders they would reject are logged and counted
+	shadowRules        []Rule
 	clientsInstruments map[uint32]map[string]*instrument
+	accounts           map[uint32]*account
+	// instruments holds open orders per instrument over all clients
+	instruments map[string]*instrument
 }
 
-func NewOrdersService(ordersLimit uint, volumeSumLimit float64) *ordersService {
-	log.Printf("orders service started. open orders limit: %d, sum of volumes limit: %f\n", ordersLimit, volumeSumLimit)
+// OrdersOption configures optional behaviour of the orders service
+type OrdersOption func(*ordersService)
+
+// WithRules adds the rules to the chain after the built-in N and S limits
+func WithRules(rules ...Rule) OrdersOption {
+	return func(svc *ordersService) {
+		svc.rules = append(svc.rules, rules...)
+	}
+}
 
-	return &ordersService{
-		ordersLimit:        ordersLimit,
-		volumeSumLimit:     volumeSumLimit,
+// WithShadowRules evaluates the rules against orders accepted by the live rules
+// without rejecting them, to find out who would be affected by new limits
+func WithShadowRules(rules ...Rule) OrdersOption {
+	return func(svc *ordersService) {
+		svc.shadowRules = append(svc.shadowRules, rules...)
+	}
+}
+
+func NewOrdersService(ordersLimit uint, volumeSumLimit float64, opts ...OrdersOption) *ordersService {
+	svc := &ordersService{
+		rules:              []Rule{OrdersLimit(ordersLimit), VolumeLimit(volumeSumLimit)},
 		clientsInstruments: make(map[uint32]map[string]*instrument),
+		accounts:           make(map[uint32]*account),
+		instruments:        make(map[string]*instrument),
+	}
+	for _, opt := range opts {
+		opt(svc)
+	}
+	log.Printf("orders service started. open orders limit: %d, sum of volumes limit: %f\n", ordersLimit, volumeSumLimit)
+	for _, rule := range svc.rules[2:] {
+		log.Printf("rule %T: %v\n", rule, rule)
+	}
+	for _, rule := range svc.shadowRules {
+		log.Printf("shadow rule %T: %v\n", rule, rule)
 	}
+
+	return svc
 }
 
 // ProcessOrder is an entry point in orders service
 func (svc *ordersService) ProcessOrder(order model.OrderRequest) error {
+	svc.Lock()
+	defer svc.Unlock()
+	return svc.processOrder(order)
+}
+
+// ProcessBatch processes orders of a batch in one critical section and returns
+// an error per order. In atomic mode either all orders are applied or none:
+// if one fails, the already applied ones are rolled back and rejected with
+// ErrBatchRejected.
+func (svc *ordersService) ProcessBatch(orders []model.OrderRequest, atomic bool) []error {
+	svc.Lock()
+	defer svc.Unlock()
+
+	errs := make([]error, len(orders))
+	for i, order := range orders {
+		errs[i] = svc.processOrder(order)
+		if errs[i] == nil || !atomic {
+			continue
+		}
+
+		for j := i - 1; j >= 0; j-- {
+			svc.rollback(orders[j])
+		}
+		for j := range errs {
+			if errs[j] == nil {
+				errs[j] = model.ErrBatchRejected
+			}
+		}
+		break
+	}
+	return errs
+}
+
+// Rollback reverts an accepted order, like one the order server
+// never answered
+func (svc *ordersService) Rollback(order model.OrderRequest) {
+	svc.Lock()
+	defer svc.Unlock()
+	svc.rollback(order)
+}
+
+// ClosingOrders returns close orders flattening open positions of the client,
+// one per instrument and side with open volume
+func (svc *ordersService) ClosingOrders(clientID uint32) []model.OrderRequest {
+	svc.Lock()
+	defer svc.Unlock()
+	var res []model.OrderRequest
+	for name, instr := range svc.clientsInstruments[clientID] {
+		for kind, volume := range map[model.OrderKind]float64{
+			model.OrderKindBuy:  instr.buyVolume,
+			model.OrderKindSell: instr.sellVolume,
+		} {
+			if volume <= 0 {
+				continue
+			}
+			res = append(res, model.OrderRequest{
+				ClientID:   clientID,
+				ReqType:    model.RequestTypeClose,
+				OrderKind:  kind,
+				Volume:     volume,
+				Instrument: name,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Instrument != res[j].Instrument {
+			return res[i].Instrument < res[j].Instrument
+		}
+		return res[i].OrderKind < res[j].OrderKind
+	})
+	return res
+}
+
+// ForceClose applies a close order the order server accepted on behalf of
+// the client, bypassing the rules which may block it. A close takes a single
+// order off the position, so orders left once the position is flat are
+// dropped along with it.
+func (svc *ordersService) ForceClose(order model.OrderRequest) {
+	svc.Lock()
+	defer svc.Unlock()
+	instr, ok := svc.clientsInstruments[order.ClientID][order.Instrument]
+	if !ok || instr.count == 0 || checkSide(order, instr.position()) != nil {
+		log.Printf("force close: order of client %d on %s isn't open", order.ClientID, order.Instrument)
+		return
+	}
+	svc.apply(order)
+	if instr.buyVolume > 0 || instr.sellVolume > 0 || instr.count == 0 {
+		return
+	}
+	left := instr.count
+	instr.count = 0
+	svc.account(order.ClientID).count -= left
+	svc.instrument(order.Instrument).count -= left
+}
+
+func (svc *ordersService) processOrder(order model.OrderRequest) error {
 	switch order.ReqType {
 	case model.RequestTypeOpen:
 		return svc.openOrder(order)
@@ -45,51 +207,120 @@ func (svc *ordersService) ProcessOrder(order model.OrderRequest) error {
 }
 
 func (svc *ordersService) openOrder(order model.OrderRequest) error {
-	if svc.ordersLimit == 0 {
-		return model.ErrNumberExceedes
-	}
-	clientID, orderInstrument, volume := order.ClientID, order.Instrument, order.Volume
-	if volume > svc.volumeSumLimit {
-		return model.ErrVolumeSumExceedes
+	if err := svc.check(order); err != nil {
+		return err
 	}
 
-	svc.Lock()
-	defer svc.Unlock()
-	instrumentMap, clientExists := svc.clientsInstruments[clientID]
+	instrumentMap, clientExists := svc.clientsInstruments[order.ClientID]
 	if !clientExists {
-		svc.clientsInstruments[clientID] = make(map[string]*instrument)
-		svc.clientsInstruments[clientID][orderInstrument] = &instrument{
-			count:     1,
-			volumeSum: volume,
+		instrumentMap = make(map[string]*instrument)
+		svc.clientsInstruments[order.ClientID] = instrumentMap
+	}
+	if _, instrumentExist := instrumentMap[order.Instrument]; !instrumentExist {
+		instrumentMap[order.Instrument] = &instrument{}
+	}
+	svc.apply(order)
+
+	return nil
+}
+
+// apply adds the order to the open orders and the stateful rules
+func (svc *ordersService) apply(order model.OrderRequest) {
+	svc.clientsInstruments[order.ClientID][order.Instrument].apply(order)
+	svc.account(order.ClientID).apply(order)
+	svc.instrument(order.Instrument).apply(order)
+	for _, rule := range svc.allRules() {
+		if stateful, ok := rule.(StatefulRule); ok {
+			stateful.Apply(order)
 		}
-		return nil
 	}
-	instr, instrumentExist := instrumentMap[orderInstrument]
-	if !instrumentExist {
-		instrumentMap[orderInstrument] = &instrument{
-			count:     1,
-			volumeSum: volume,
+}
+
+// check runs the order through the rules
+func (svc *ordersService) check(order model.OrderRequest) error {
+	state := svc.state(order)
+	for _, rule := range svc.rules {
+		err := rule.Check(order, state)
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, model.ErrInstrumentLimitExceedes) {
+			metrics.InstrumentLimitRejections.Add(order.Instrument, 1)
 		}
-		return nil
+		return err
 	}
+	svc.checkShadow(order, state)
+	return nil
+}
 
-	if instr.count+1 > svc.ordersLimit {
-		return model.ErrNumberExceedes
+// checkShadow runs the accepted order through the shadow rules. Clients
+// are only logged, metrics keyed by clients would grow without bound.
+func (svc *ordersService) checkShadow(order model.OrderRequest, state State) {
+	for _, rule := range svc.shadowRules {
+		err := rule.Check(order, state)
+		if err == nil {
+			continue
+		}
+		log.Printf("shadow: order %d of client %d on %s would be rejected: %v",
+			order.ID, order.ClientID, order.Instrument, err)
+		metrics.ShadowRejections.Add(err.Error(), 1)
+		return
 	}
-	if instr.volumeSum+volume > svc.volumeSumLimit {
-		return model.ErrVolumeSumExceedes
+}
+
+// allRules returns both live and shadow rules
+func (svc *ordersService) allRules() []Rule {
+	if len(svc.shadowRules) == 0 {
+		return svc.rules
 	}
-	instr.count++
-	instr.volumeSum += volume
+	return append(svc.rules[:len(svc.rules):len(svc.rules)], svc.shadowRules...)
+}
 
-	return nil
+// state returns the open orders the order is checked against
+func (svc *ordersService) state(order model.OrderRequest) State {
+	var state State
+	if instr, ok := svc.clientsInstruments[order.ClientID][order.Instrument]; ok {
+		state.Position = instr.position()
+	}
+	if acc, ok := svc.accounts[order.ClientID]; ok {
+		state.Account = acc.snapshot()
+	}
+	if instr, ok := svc.instruments[order.Instrument]; ok {
+		state.Instrument = instr.position()
+	}
+	return state
+}
+
+// account returns the account of the client, creating it if needed
+func (svc *ordersService) account(clientID uint32) *account {
+	if svc.accounts == nil {
+		svc.accounts = make(map[uint32]*account)
+	}
+	acc, ok := svc.accounts[clientID]
+	if !ok {
+		acc = newAccount()
+		svc.accounts[clientID] = acc
+	}
+	return acc
+}
+
+// instrument returns open orders of the instrument over all clients,
+// creating them if needed
+func (svc *ordersService) instrument(name string) *instrument {
+	if svc.instruments == nil {
+		svc.instruments = make(map[string]*instrument)
+	}
+	instr, ok := svc.instruments[name]
+	if !ok {
+		instr = &instrument{}
+		svc.instruments[name] = instr
+	}
+	return instr
 }
 
 func (svc *ordersService) closeOrder(order model.OrderRequest) error {
 	clientID, orderInstrument, volume := order.ClientID, order.Instrument, order.Volume
 
-	svc.Lock()
-	defer svc.Unlock()
 	instrumentMap, clientExists := svc.clientsInstruments[clientID]
 	if !clientExists {
 		return model.ErrNoOrderToClose
@@ -104,8 +335,39 @@ func (svc *ordersService) closeOrder(order model.OrderRequest) error {
 	if instr.volumeSum-volume < 0 {
 		return model.ErrNegativeVolumeSum
 	}
-	instr.count--
-	instr.volumeSum -= volume
+	if err := svc.check(order); err != nil {
+		return err
+	}
+	svc.apply(order)
 
 	return nil
 }
+
+// rollback reverts an already applied order, must be called under the lock.
+// An open order the client has closed meanwhile isn't held by the position
+// anymore and is left as is, so the position never goes below zero.
+func (svc *ordersService) rollback(order model.OrderRequest) {
+	if order.ReqType == model.RequestTypeOpen && !svc.holds(order) {
+		log.Printf("rollback: order %d of client %d on %s is already closed",
+			order.ID, order.ClientID, order.Instrument)
+		return
+	}
+	for _, rule := range svc.allRules() {
+		if stateful, ok := rule.(StatefulRule); ok {
+			stateful.Revert(order)
+		}
+	}
+	order = reversed(order)
+	svc.clientsInstruments[order.ClientID][order.Instrument].apply(order)
+	svc.account(order.ClientID).apply(order)
+	svc.instrument(order.Instrument).apply(order)
+}
+
+// holds tells whether the client's position has room to remove the open order
+func (svc *ordersService) holds(order model.OrderRequest) bool {
+	instr, ok := svc.clientsInstruments[order.ClientID][order.Instrument]
+	if !ok || instr.count == 0 || instr.volumeSum-order.Volume < 0 {
+		return false
+	}
+	return checkSide(order, instr.position()) == nil
+}
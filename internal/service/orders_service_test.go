@@ -3,7 +3,9 @@ package service
 import (
 	"errors"
 	"testing"
+	"time"
 
+	"test.task/backend/proxy/internal/metrics"
 	"test.task/backend/proxy/internal/model"
 )
 
@@ -24,8 +26,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "invalid request type",
 			service: &ordersService{
-				ordersLimit:    1,
-				volumeSumLimit: 200,
+				rules: []Rule{OrdersLimit(1), VolumeLimit(200)},
 			},
 			input: model.OrderRequest{
 				ReqType: 4,
@@ -35,8 +36,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "open order success",
 			service: &ordersService{
-				ordersLimit:        1,
-				volumeSumLimit:     200,
+				rules:              []Rule{OrdersLimit(1), VolumeLimit(200)},
 				clientsInstruments: make(map[uint32]map[string]*instrument),
 			},
 			input: model.OrderRequest{
@@ -52,8 +52,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "increase volume and count",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -76,8 +75,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "instrument not exists on client",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {},
 				},
@@ -95,8 +93,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "open order with restricted limit",
 			service: &ordersService{
-				ordersLimit:    0,
-				volumeSumLimit: 100,
+				rules: []Rule{OrdersLimit(0), VolumeLimit(100)},
 			},
 			input: model.OrderRequest{
 				ClientID:   clientID,
@@ -108,8 +105,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "open order with number of orders exceedes",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 1000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(1000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -129,8 +125,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "open order with restricted sum limit",
 			service: &ordersService{
-				ordersLimit:    10,
-				volumeSumLimit: 0,
+				rules: []Rule{OrdersLimit(10), VolumeLimit(0)},
 			},
 			input: model.OrderRequest{
 				ClientID:   clientID,
@@ -143,8 +138,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "open order with sum of volumes exceedes",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 3000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(3000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -165,8 +159,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "close order success",
 			service: &ordersService{
-				ordersLimit:    5,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(5), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -202,8 +195,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "close order no instrument",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {},
 				},
@@ -219,8 +211,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "close order zero orders",
 			service: &ordersService{
-				ordersLimit:    2,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(2), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -239,8 +230,7 @@ func TestProcessOrder(t *testing.T) {
 		{
 			name: "close order negative volume sum",
 			service: &ordersService{
-				ordersLimit:    5,
-				volumeSumLimit: 4000,
+				rules: []Rule{OrdersLimit(5), VolumeLimit(4000)},
 				clientsInstruments: map[uint32]map[string]*instrument{
 					clientID: {
 						instrumentName: {
@@ -278,3 +268,414 @@ func TestProcessOrder(t *testing.T) {
 		}
 	}
 }
+
+func TestProcessBatch(t *testing.T) {
+	clientID := uint32(1)
+	instrumentName := "USDRUB"
+	open := func(volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   clientID,
+			ReqType:    model.RequestTypeOpen,
+			Volume:     volume,
+			Instrument: instrumentName,
+		}
+	}
+
+	cases := []struct {
+		name       string
+		atomic     bool
+		input      []model.OrderRequest
+		wantErrs   []error
+		wantCount  uint
+		wantVolume float64
+	}{
+		{
+			name:       "individual all accepted",
+			input:      []model.OrderRequest{open(100), open(200)},
+			wantErrs:   []error{nil, nil},
+			wantCount:  2,
+			wantVolume: 300,
+		},
+		{
+			name:       "individual one rejected",
+			input:      []model.OrderRequest{open(100), open(1000), open(200)},
+			wantErrs:   []error{nil, model.ErrVolumeSumExceedes, nil},
+			wantCount:  2,
+			wantVolume: 300,
+		},
+		{
+			name:       "atomic all accepted",
+			atomic:     true,
+			input:      []model.OrderRequest{open(100), open(200)},
+			wantErrs:   []error{nil, nil},
+			wantCount:  2,
+			wantVolume: 300,
+		},
+		{
+			name:       "atomic rolled back",
+			atomic:     true,
+			input:      []model.OrderRequest{open(100), open(200), open(1000), open(50)},
+			wantErrs:   []error{model.ErrBatchRejected, model.ErrBatchRejected, model.ErrVolumeSumExceedes, model.ErrBatchRejected},
+			wantCount:  0,
+			wantVolume: 0,
+		},
+	}
+	for _, tc := range cases {
+		svc := &ordersService{
+			rules:              []Rule{OrdersLimit(3), VolumeLimit(500)},
+			clientsInstruments: make(map[uint32]map[string]*instrument),
+		}
+
+		errs := svc.ProcessBatch(tc.input, tc.atomic)
+		for i := range tc.wantErrs {
+			if !errors.Is(errs[i], tc.wantErrs[i]) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, tc.wantErrs[i], errs[i])
+			}
+		}
+		instr := svc.clientsInstruments[clientID][instrumentName]
+		if instr.count != tc.wantCount {
+			t.Fatalf("%s failed: expected count: %d, got: %d", tc.name, tc.wantCount, instr.count)
+		}
+		if instr.volumeSum != tc.wantVolume {
+			t.Fatalf("%s failed: expected volume: %f, got: %f", tc.name, tc.wantVolume, instr.volumeSum)
+		}
+	}
+}
+
+func TestExposureLimits(t *testing.T) {
+	clientID := uint32(1)
+	instrumentName := "USDRUB"
+	order := func(reqType model.RequestType, kind model.OrderKind, volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   clientID,
+			ReqType:    reqType,
+			OrderKind:  kind,
+			Volume:     volume,
+			Instrument: instrumentName,
+		}
+	}
+	buy := func(volume float64) model.OrderRequest {
+		return order(model.RequestTypeOpen, model.OrderKindBuy, volume)
+	}
+	sell := func(volume float64) model.OrderRequest {
+		return order(model.RequestTypeOpen, model.OrderKindSell, volume)
+	}
+
+	cases := []struct {
+		name     string
+		rules    []Rule
+		input    []model.OrderRequest
+		wantErrs []error
+		wantNet  float64
+	}{
+		{
+			name:     "hedged client within net limit",
+			rules:    []Rule{NetExposureLimit(300)},
+			input:    []model.OrderRequest{buy(300), sell(300), buy(300)},
+			wantErrs: []error{nil, nil, nil},
+			wantNet:  300,
+		},
+		{
+			name:     "one-sided client exceeds net limit",
+			rules:    []Rule{NetExposureLimit(300)},
+			input:    []model.OrderRequest{buy(200), buy(200)},
+			wantErrs: []error{nil, model.ErrNetExposureExceedes},
+			wantNet:  200,
+		},
+		{
+			name:     "order reducing exposure accepted above limit",
+			rules:    []Rule{NetExposureLimit(100)},
+			input:    []model.OrderRequest{sell(100), sell(50), buy(20), buy(400)},
+			wantErrs: []error{nil, model.ErrNetExposureExceedes, nil, model.ErrNetExposureExceedes},
+			wantNet:  -80,
+		},
+		{
+			name:     "gross limit counts both sides",
+			rules:    []Rule{NetExposureLimit(300), GrossExposureLimit(700)},
+			input:    []model.OrderRequest{buy(300), sell(300), buy(200)},
+			wantErrs: []error{nil, nil, model.ErrGrossExposureExceedes},
+			wantNet:  0,
+		},
+		{
+			name:     "close more than opened on a side",
+			rules:    []Rule{NetExposureLimit(300)},
+			input:    []model.OrderRequest{buy(100), sell(100), order(model.RequestTypeClose, model.OrderKindBuy, 150)},
+			wantErrs: []error{nil, nil, model.ErrNegativeVolumeSum},
+			wantNet:  0,
+		},
+		{
+			name:     "close one side of a hedge",
+			rules:    []Rule{NetExposureLimit(100)},
+			input:    []model.OrderRequest{buy(100), sell(100), buy(100), order(model.RequestTypeClose, model.OrderKindSell, 100)},
+			wantErrs: []error{nil, nil, nil, nil},
+			wantNet:  200,
+		},
+	}
+	for _, tc := range cases {
+		svc := NewOrdersService(10, 4000, WithRules(tc.rules...))
+		for i, order := range tc.input {
+			if err := svc.ProcessOrder(order); !errors.Is(err, tc.wantErrs[i]) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, tc.wantErrs[i], err)
+			}
+		}
+		instr := svc.clientsInstruments[clientID][instrumentName]
+		if instr.position().Net() != tc.wantNet {
+			t.Fatalf("%s failed: expected net exposure: %f, got: %f", tc.name, tc.wantNet, instr.position().Net())
+		}
+	}
+}
+
+func TestAccountLimits(t *testing.T) {
+	clientID := uint32(1)
+	open := func(instrument string, volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   clientID,
+			ReqType:    model.RequestTypeOpen,
+			OrderKind:  model.OrderKindBuy,
+			Volume:     volume,
+			Instrument: instrument,
+		}
+	}
+	closeOrder := open("USDRUB", 100)
+	closeOrder.ReqType = model.RequestTypeClose
+
+	cases := []struct {
+		name     string
+		rules    []Rule
+		input    []model.OrderRequest
+		wantErrs []error
+	}{
+		{
+			name:     "orders over all instruments",
+			rules:    []Rule{ClientOrdersLimit(2)},
+			input:    []model.OrderRequest{open("USDRUB", 100), open("XLMEUR", 100), open("BTCUSD", 100)},
+			wantErrs: []error{nil, nil, model.ErrClientOrdersExceedes},
+		},
+		{
+			name:     "closed order frees the client limit",
+			rules:    []Rule{ClientOrdersLimit(2)},
+			input:    []model.OrderRequest{open("USDRUB", 100), open("XLMEUR", 100), closeOrder, open("BTCUSD", 100)},
+			wantErrs: []error{nil, nil, nil, nil},
+		},
+		{
+			name:     "volume over all instruments",
+			rules:    []Rule{ClientVolumeLimit(500)},
+			input:    []model.OrderRequest{open("USDRUB", 300), open("XLMEUR", 300)},
+			wantErrs: []error{nil, model.ErrClientVolumeExceedes},
+		},
+		{
+			name:     "currency shared by instruments",
+			rules:    []Rule{CurrencyLimit(500)},
+			input:    []model.OrderRequest{open("USDRUB", 300), open("XLMEUR", 300), open("EURUSD", 300)},
+			wantErrs: []error{nil, nil, model.ErrCurrencyExposureExceedes},
+		},
+		{
+			name:     "instrument without currencies",
+			rules:    []Rule{CurrencyLimit(500)},
+			input:    []model.OrderRequest{open("GOLD", 300), open("GOLD", 300)},
+			wantErrs: []error{nil, nil},
+		},
+	}
+	for _, tc := range cases {
+		svc := NewOrdersService(10, 4000, WithRules(tc.rules...))
+		for i, order := range tc.input {
+			if err := svc.ProcessOrder(order); !errors.Is(err, tc.wantErrs[i]) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, tc.wantErrs[i], err)
+			}
+		}
+	}
+
+	svc := NewOrdersService(10, 4000, WithRules(ClientOrdersLimit(2)))
+	errs := svc.ProcessBatch([]model.OrderRequest{open("USDRUB", 100), open("XLMEUR", 100), open("BTCUSD", 100)}, true)
+	if !errors.Is(errs[2], model.ErrClientOrdersExceedes) {
+		t.Fatalf("expected err: %v, got: %v", model.ErrClientOrdersExceedes, errs[2])
+	}
+	if acc := svc.accounts[clientID]; acc.count != 0 || acc.volumeSum != 0 || acc.currencies["USD"] != 0 {
+		t.Fatalf("expected rolled back account, got: %+v", acc)
+	}
+}
+
+func TestInstrumentLimits(t *testing.T) {
+	open := func(clientID uint32, instrument string, volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   clientID,
+			ReqType:    model.RequestTypeOpen,
+			OrderKind:  model.OrderKindBuy,
+			Volume:     volume,
+			Instrument: instrument,
+		}
+	}
+
+	cases := []struct {
+		name     string
+		rules    []Rule
+		input    []model.OrderRequest
+		wantErrs []error
+	}{
+		{
+			name:     "orders of all clients",
+			rules:    []Rule{InstrumentOrdersLimit(2)},
+			input:    []model.OrderRequest{open(1, "USDRUB", 100), open(2, "USDRUB", 100), open(3, "USDRUB", 100), open(3, "XLMEUR", 100)},
+			wantErrs: []error{nil, nil, model.ErrInstrumentLimitExceedes, nil},
+		},
+		{
+			name:     "volume of all clients",
+			rules:    []Rule{InstrumentVolumeLimit(500)},
+			input:    []model.OrderRequest{open(1, "USDRUB", 300), open(2, "USDRUB", 300), open(2, "USDRUB", 200)},
+			wantErrs: []error{nil, model.ErrInstrumentLimitExceedes, nil},
+		},
+	}
+	for _, tc := range cases {
+		svc := NewOrdersService(10, 4000, WithRules(tc.rules...))
+		before := rejections("USDRUB")
+		rejected := 0
+		for i, order := range tc.input {
+			err := svc.ProcessOrder(order)
+			if !errors.Is(err, tc.wantErrs[i]) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, tc.wantErrs[i], err)
+			}
+			if err != nil {
+				rejected++
+			}
+		}
+		if got := rejections("USDRUB") - before; got != int64(rejected) {
+			t.Fatalf("%s failed: expected %d rejections in metrics, got: %d", tc.name, rejected, got)
+		}
+	}
+
+	svc := NewOrdersService(10, 4000, WithRules(InstrumentOrdersLimit(1)))
+	closeOrder := open(1, "USDRUB", 100)
+	closeOrder.ReqType = model.RequestTypeClose
+	for i, order := range []model.OrderRequest{open(1, "USDRUB", 100), closeOrder, open(2, "USDRUB", 100)} {
+		if err := svc.ProcessOrder(order); err != nil {
+			t.Fatalf("order %d: expected no error, got: %v", i, err)
+		}
+	}
+}
+
+func rejections(instrument string) int64 {
+	return counter(metrics.InstrumentLimitRejections, instrument)
+}
+
+func TestRollback(t *testing.T) {
+	windows := NewVolumeWindows(Window{Period: time.Hour, Limit: 1000})
+	svc := NewOrdersService(3, 500, WithRules(windows))
+	order := func(id uint32, volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   1,
+			ID:         id,
+			ReqType:    model.RequestTypeOpen,
+			OrderKind:  model.OrderKindBuy,
+			Volume:     volume,
+			Instrument: "USDRUB",
+		}
+	}
+	for i, volume := range []float64{100, 200} {
+		if err := svc.ProcessOrder(order(uint32(i+1), volume)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	svc.Rollback(order(1, 100))
+	instr := svc.clientsInstruments[1]["USDRUB"]
+	if instr.count != 1 || instr.volumeSum != 200 {
+		t.Fatalf("expected count 1 and volume 200, got: %+v", instr)
+	}
+	if acc := svc.accounts[1]; acc.count != 1 || acc.volumeSum != 200 {
+		t.Fatalf("expected account count 1 and volume 200, got: %+v", acc)
+	}
+	if entries := windows.opened[1]["USDRUB"]; len(entries) != 1 || entries[0].volume != 200 {
+		t.Fatalf("expected window volume 200, got: %+v", entries)
+	}
+}
+
+func TestRollbackAfterClose(t *testing.T) {
+	svc := NewOrdersService(4, 1000)
+	order := func(reqType model.RequestType) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   1,
+			ReqType:    reqType,
+			OrderKind:  model.OrderKindBuy,
+			Volume:     100,
+			Instrument: "USDRUB",
+		}
+	}
+	if err := svc.ProcessOrder(order(model.RequestTypeOpen)); err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.ProcessOrder(order(model.RequestTypeClose)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the open times out upstream after the client closed it
+	svc.Rollback(order(model.RequestTypeOpen))
+	instr := svc.clientsInstruments[1]["USDRUB"]
+	if instr.count != 0 || instr.volumeSum != 0 || instr.buyVolume != 0 {
+		t.Fatalf("expected empty position, got: %+v", instr)
+	}
+	if acc := svc.accounts[1]; acc.count != 0 || acc.volumeSum != 0 {
+		t.Fatalf("expected empty account, got: %+v", acc)
+	}
+	if instr := svc.instruments["USDRUB"]; instr.count != 0 || instr.volumeSum != 0 {
+		t.Fatalf("expected no open orders on the instrument, got: %+v", instr)
+	}
+	for i := 0; i < 4; i++ {
+		if err := svc.ProcessOrder(order(model.RequestTypeOpen)); err != nil {
+			t.Fatalf("open %d: %v", i+1, err)
+		}
+	}
+	if err := svc.ProcessOrder(order(model.RequestTypeOpen)); !errors.Is(err, model.ErrNumberExceedes) {
+		t.Fatalf("expected N to be enforced, got: %v", err)
+	}
+}
+
+func TestForceClose(t *testing.T) {
+	svc := NewOrdersService(4, 1000)
+	open := func(kind model.OrderKind, volume float64, instrument string) {
+		t.Helper()
+		order := model.OrderRequest{ClientID: 1, ReqType: model.RequestTypeOpen, OrderKind: kind, Volume: volume, Instrument: instrument}
+		if err := svc.ProcessOrder(order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	open(model.OrderKindBuy, 100, "USDRUB")
+	open(model.OrderKindBuy, 200, "USDRUB")
+	open(model.OrderKindSell, 50, "USDRUB")
+	open(model.OrderKindSell, 10, "EURUSD")
+
+	closes := svc.ClosingOrders(1)
+	want := []model.OrderRequest{
+		{ClientID: 1, ReqType: model.RequestTypeClose, OrderKind: model.OrderKindSell, Volume: 10, Instrument: "EURUSD"},
+		{ClientID: 1, ReqType: model.RequestTypeClose, OrderKind: model.OrderKindBuy, Volume: 300, Instrument: "USDRUB"},
+		{ClientID: 1, ReqType: model.RequestTypeClose, OrderKind: model.OrderKindSell, Volume: 50, Instrument: "USDRUB"},
+	}
+	if len(closes) != len(want) {
+		t.Fatalf("expected closes %+v, got: %+v", want, closes)
+	}
+	for i := range want {
+		if closes[i] != want[i] {
+			t.Fatalf("expected closes %+v, got: %+v", want, closes)
+		}
+	}
+
+	// the server rejected the close of EURUSD, so it stays open
+	svc.ForceClose(closes[1])
+	svc.ForceClose(closes[2])
+	if instr := svc.clientsInstruments[1]["USDRUB"]; instr.count != 0 || instr.volumeSum != 0 {
+		t.Fatalf("expected flat position, got: %+v", instr)
+	}
+	if acc := svc.accounts[1]; acc.count != 1 || acc.volumeSum != 10 {
+		t.Fatalf("expected the EURUSD order left in the account, got: %+v", acc)
+	}
+	if instr := svc.instruments["USDRUB"]; instr.count != 0 || instr.volumeSum != 0 {
+		t.Fatalf("expected no open orders on the instrument, got: %+v", instr)
+	}
+	// closing again is a no-op
+	svc.ForceClose(closes[1])
+	if instr := svc.clientsInstruments[1]["USDRUB"]; instr.count != 0 || instr.volumeSum != 0 {
+		t.Fatalf("expected flat position, got: %+v", instr)
+	}
+	if closes := svc.ClosingOrders(1); len(closes) != 1 || closes[0] != want[0] {
+		t.Fatalf("expected only the close of EURUSD left, got: %+v", closes)
+	}
+}
@@ -0,0 +1,123 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+// Window limits the volume opened per client per instrument
+// during the last Period
+type Window struct {
+	Period time.Duration
+	Limit  float64
+}
+
+type opened struct {
+	id     uint32
+	at     time.Time
+	volume float64
+}
+
+// VolumeWindows keeps the volume opened per client per instrument over
+// rolling time windows and rejects orders exceeding any of the windows.
+// Closing an order doesn't give the volume back. It's a StatefulRule and
+// relies on the lock of the orders service.
+type VolumeWindows struct {
+	windows []Window
+	// longest is the period entries are kept for
+	longest time.Duration
+	now     func() time.Time
+	opened  map[uint32]map[string][]opened
+}
+
+func NewVolumeWindows(windows ...Window) *VolumeWindows {
+	w := &VolumeWindows{
+		windows: windows,
+		now:     time.Now,
+		opened:  make(map[uint32]map[string][]opened),
+	}
+	for _, window := range windows {
+		if window.Period > w.longest {
+			w.longest = window.Period
+		}
+	}
+	return w
+}
+
+func (w *VolumeWindows) String() string {
+	return fmt.Sprint(w.windows)
+}
+
+func (w *VolumeWindows) Check(order model.OrderRequest, _ State) error {
+	if order.ReqType != model.RequestTypeOpen {
+		return nil
+	}
+	// entries older than the longest window are pruned by Apply,
+	// the ones left are counted in the windows they're in
+	entries := w.opened[order.ClientID][order.Instrument]
+	now := w.now()
+	for _, window := range w.windows {
+		sum := order.Volume
+		for i := len(entries) - 1; i >= 0 && now.Sub(entries[i].at) < window.Period; i-- {
+			sum += entries[i].volume
+		}
+		if sum > window.Limit {
+			return fmt.Errorf("%w: %s", model.ErrWindowVolumeExceedes, window.Period)
+		}
+	}
+	return nil
+}
+
+func (w *VolumeWindows) Apply(order model.OrderRequest) {
+	if order.ReqType != model.RequestTypeOpen {
+		return
+	}
+	instruments, ok := w.opened[order.ClientID]
+	if !ok {
+		instruments = make(map[string][]opened)
+		w.opened[order.ClientID] = instruments
+	}
+	entries := w.prune(order)
+	instruments[order.Instrument] = append(entries, opened{id: order.ID, at: w.now(), volume: order.Volume})
+}
+
+// Revert removes the latest opened volume of the order, a client may reuse IDs
+func (w *VolumeWindows) Revert(order model.OrderRequest) {
+	if order.ReqType != model.RequestTypeOpen {
+		return
+	}
+	entries := w.opened[order.ClientID][order.Instrument]
+	for i := len(entries) - 1; i >= 0; i-- {
+		if entries[i].id != order.ID {
+			continue
+		}
+		if entries = append(entries[:i], entries[i+1:]...); len(entries) == 0 {
+			delete(w.opened[order.ClientID], order.Instrument)
+			return
+		}
+		w.opened[order.ClientID][order.Instrument] = entries
+		return
+	}
+}
+
+// prune drops entries older than the longest window and returns the rest
+func (w *VolumeWindows) prune(order model.OrderRequest) []opened {
+	entries := w.opened[order.ClientID][order.Instrument]
+	now := w.now()
+	i := 0
+	for i < len(entries) && now.Sub(entries[i].at) >= w.longest {
+		i++
+	}
+	if i == 0 {
+		return entries
+	}
+	entries = append(entries[:0], entries[i:]...)
+	if len(entries) == 0 {
+		delete(w.opened[order.ClientID], order.Instrument)
+		return nil
+	}
+	w.opened[order.ClientID][order.Instrument] = entries
+	return entries
+}
@@ -0,0 +1,131 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+func TestVolumeWindows(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	open := model.OrderRequest{
+		ClientID:   1,
+		ReqType:    model.RequestTypeOpen,
+		OrderKind:  model.OrderKindBuy,
+		Volume:     100,
+		Instrument: "USDRUB",
+	}
+	closeOrder := open
+	closeOrder.ReqType = model.RequestTypeClose
+	otherInstrument := open
+	otherInstrument.Instrument = "XLMEUR"
+
+	type step struct {
+		after   time.Duration
+		order   model.OrderRequest
+		wantErr error
+	}
+	cases := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "minute window exceeded",
+			steps: []step{
+				{0, open, nil},
+				{10 * time.Second, open, nil},
+				{20 * time.Second, open, model.ErrWindowVolumeExceedes},
+				{20 * time.Second, otherInstrument, nil},
+			},
+		},
+		{
+			name: "closing doesn't give volume back",
+			steps: []step{
+				{0, open, nil},
+				{0, closeOrder, nil},
+				{0, open, nil},
+				{0, open, model.ErrWindowVolumeExceedes},
+			},
+		},
+		{
+			name: "volume leaves minute window",
+			steps: []step{
+				{0, open, nil},
+				{0, open, nil},
+				{time.Minute, open, nil},
+				{time.Minute, open, nil},
+			},
+		},
+		{
+			name: "hour window exceeded",
+			steps: []step{
+				{0, open, nil},
+				{0, open, nil},
+				{time.Minute, open, nil},
+				{time.Minute, open, nil},
+				{2 * time.Minute, open, model.ErrWindowVolumeExceedes},
+				{time.Hour, open, nil},
+			},
+		},
+	}
+	for _, tc := range cases {
+		windows := NewVolumeWindows(Window{Period: time.Minute, Limit: 200}, Window{Period: time.Hour, Limit: 400})
+		now := start
+		windows.now = func() time.Time { return now }
+		svc := NewOrdersService(10, 4000, WithRules(windows))
+
+		for i, s := range tc.steps {
+			now = start.Add(s.after)
+			if err := svc.ProcessOrder(s.order); !errors.Is(err, s.wantErr) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, s.wantErr, err)
+			}
+		}
+	}
+
+	windows := NewVolumeWindows(Window{Period: time.Minute, Limit: 200})
+	svc := NewOrdersService(10, 4000, WithRules(windows))
+	errs := svc.ProcessBatch([]model.OrderRequest{open, open, open}, true)
+	if !errors.Is(errs[2], model.ErrWindowVolumeExceedes) {
+		t.Fatalf("expected err: %v, got: %v", model.ErrWindowVolumeExceedes, errs[2])
+	}
+	if err := svc.ProcessOrder(open); err != nil {
+		t.Fatalf("expected rolled back volume, got: %v", err)
+	}
+}
+
+func TestVolumeWindowsState(t *testing.T) {
+	start := time.Date(2020, 1, 1, 10, 0, 0, 0, time.UTC)
+	now := start
+	windows := NewVolumeWindows(Window{Period: time.Minute, Limit: 300})
+	windows.now = func() time.Time { return now }
+	order := func(id uint32) model.OrderRequest {
+		return model.OrderRequest{ClientID: 1, ID: id, ReqType: model.RequestTypeOpen, Volume: 100, Instrument: "USDRUB"}
+	}
+
+	windows.Apply(order(1))
+	now = start.Add(30 * time.Second)
+	windows.Apply(order(2))
+
+	// reverting the first order removes its entry, not the latest one
+	// of the same volume
+	windows.Revert(order(1))
+	entries := windows.opened[1]["USDRUB"]
+	if len(entries) != 1 || entries[0].id != 2 {
+		t.Fatalf("expected entry of order 2, got: %+v", entries)
+	}
+
+	// checking an order leaves expired entries to Apply
+	now = start.Add(2 * time.Minute)
+	if err := windows.Check(order(3), State{}); err != nil {
+		t.Fatal(err)
+	}
+	if entries := windows.opened[1]["USDRUB"]; len(entries) != 1 {
+		t.Fatalf("expected Check not to change the state, got: %+v", entries)
+	}
+	windows.Apply(order(3))
+	if entries := windows.opened[1]["USDRUB"]; len(entries) != 1 || entries[0].id != 3 {
+		t.Fatalf("expected expired entry to be pruned, got: %+v", entries)
+	}
+}
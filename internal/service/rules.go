@@ -0,0 +1,225 @@
+package service
+
+import (
+	"fmt"
+	"math"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+// Rule is a risk check of an order against the open orders. It returns nil
+// to allow the order or the reason of rejection, which is translated
+// to the result code by the order adapter. Rules are checked for both
+// open and close orders under the lock of the orders service and must not
+// modify the state.
+type Rule interface {
+	Check(order model.OrderRequest, state State) error
+}
+
+// StatefulRule is a rule keeping its own state of accepted orders. Apply is
+// called once an order is accepted and Revert once an accepted order is rolled
+// back, both under the lock of the orders service.
+type StatefulRule interface {
+	Rule
+	Apply(order model.OrderRequest)
+	Revert(order model.OrderRequest)
+}
+
+// RuleFunc is a function used as a Rule
+type RuleFunc func(order model.OrderRequest, state State) error
+
+func (f RuleFunc) Check(order model.OrderRequest, state State) error {
+	return f(order, state)
+}
+
+// State is the open orders an order is checked against
+type State struct {
+	// Position is the client's position on the instrument of the order
+	Position Position
+	// Account is the client's position over all instruments
+	Account Account
+	// Instrument is the position on the instrument over all clients
+	Instrument Position
+}
+
+// Position is the open orders on an instrument
+type Position struct {
+	Count      uint
+	VolumeSum  float64
+	BuyVolume  float64
+	SellVolume float64
+}
+
+// Net is buy volume minus sell volume
+func (p Position) Net() float64 {
+	return p.BuyVolume - p.SellVolume
+}
+
+// Account is the open orders of a client over all instruments
+type Account struct {
+	Count     uint
+	VolumeSum float64
+	// Currencies holds volumes per currency, it must not be modified
+	Currencies map[string]float64
+}
+
+// OrdersLimit is the N limit of open orders per client per instrument
+type OrdersLimit uint
+
+func (l OrdersLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Position.Count+1 > uint(l) {
+		return model.ErrNumberExceedes
+	}
+	return nil
+}
+
+// VolumeLimit is the S limit of the sum of volumes per client per instrument
+type VolumeLimit float64
+
+func (l VolumeLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Position.VolumeSum+order.Volume > float64(l) {
+		return model.ErrVolumeSumExceedes
+	}
+	return nil
+}
+
+// NetExposureLimit limits the absolute difference between buy and sell
+// volumes per client per instrument. An order reducing the exposure is
+// accepted even if the exposure stays above the limit. Close orders aren't
+// limited: closing one side of a hedge may raise the exposure, but a client
+// must always be able to get out of a position.
+type NetExposureLimit float64
+
+func (l NetExposureLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeClose {
+		return checkSide(order, state.Position)
+	}
+	current := state.Position.Net()
+	next := current + signed(order)
+	if math.Abs(next) > float64(l) && math.Abs(next) > math.Abs(current) {
+		return model.ErrNetExposureExceedes
+	}
+	return nil
+}
+
+// GrossExposureLimit limits the sum of buy and sell volumes
+// per client per instrument
+type GrossExposureLimit float64
+
+func (l GrossExposureLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeClose {
+		return checkSide(order, state.Position)
+	}
+	if state.Position.BuyVolume+state.Position.SellVolume+order.Volume > float64(l) {
+		return model.ErrGrossExposureExceedes
+	}
+	return nil
+}
+
+// ClientOrdersLimit limits the number of open orders per client
+// over all instruments
+type ClientOrdersLimit uint
+
+func (l ClientOrdersLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Account.Count+1 > uint(l) {
+		return model.ErrClientOrdersExceedes
+	}
+	return nil
+}
+
+// ClientVolumeLimit limits the sum of volumes of open orders per client
+// over all instruments
+type ClientVolumeLimit float64
+
+func (l ClientVolumeLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Account.VolumeSum+order.Volume > float64(l) {
+		return model.ErrClientVolumeExceedes
+	}
+	return nil
+}
+
+// CurrencyLimit limits the sum of volumes of open orders per client
+// per currency, an order on USDRUB counts towards both USD and RUB
+type CurrencyLimit float64
+
+func (l CurrencyLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType != model.RequestTypeOpen {
+		return nil
+	}
+	for _, currency := range currencies(order.Instrument) {
+		if state.Account.Currencies[currency]+order.Volume > float64(l) {
+			return model.ErrCurrencyExposureExceedes
+		}
+	}
+	return nil
+}
+
+// InstrumentOrdersLimit limits the number of open orders per instrument
+// over all clients
+type InstrumentOrdersLimit uint
+
+func (l InstrumentOrdersLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Instrument.Count+1 > uint(l) {
+		return fmt.Errorf("%w: open orders", model.ErrInstrumentLimitExceedes)
+	}
+	return nil
+}
+
+// InstrumentVolumeLimit limits the sum of volumes of open orders
+// per instrument over all clients
+type InstrumentVolumeLimit float64
+
+func (l InstrumentVolumeLimit) Check(order model.OrderRequest, state State) error {
+	if order.ReqType == model.RequestTypeOpen && state.Instrument.VolumeSum+order.Volume > float64(l) {
+		return fmt.Errorf("%w: sum of volumes", model.ErrInstrumentLimitExceedes)
+	}
+	return nil
+}
+
+// MaxOrderVolume is a fat-finger check limiting the volume of a single order
+type MaxOrderVolume float64
+
+func (l MaxOrderVolume) Check(order model.OrderRequest, _ State) error {
+	if order.ReqType == model.RequestTypeOpen && order.Volume > float64(l) {
+		return model.ErrOrderVolumeExceedes
+	}
+	return nil
+}
+
+// Blacklist rejects orders opened on the listed instruments,
+// already open orders can still be closed
+type Blacklist map[string]struct{}
+
+// NewBlacklist returns the blacklist of the instruments
+func NewBlacklist(instruments ...string) Blacklist {
+	b := make(Blacklist, len(instruments))
+	for _, instr := range instruments {
+		b[instr] = struct{}{}
+	}
+	return b
+}
+
+func (b Blacklist) Check(order model.OrderRequest, _ State) error {
+	if _, ok := b[order.Instrument]; ok && order.ReqType == model.RequestTypeOpen {
+		return model.ErrInstrumentBlacklisted
+	}
+	return nil
+}
+
+// checkSide checks that a close order doesn't close more than is open
+// on its side of the position
+func checkSide(order model.OrderRequest, p Position) error {
+	if order.OrderKind == model.OrderKindBuy && p.BuyVolume-order.Volume < 0 ||
+		order.OrderKind == model.OrderKindSell && p.SellVolume-order.Volume < 0 {
+		return model.ErrNegativeVolumeSum
+	}
+	return nil
+}
+
+// signed returns the volume of the order, negative for sells
+func signed(order model.OrderRequest) float64 {
+	if order.OrderKind == model.OrderKindSell {
+		return -order.Volume
+	}
+	return order.Volume
+}
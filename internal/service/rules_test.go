@@ -0,0 +1,114 @@
+package service
+
+import (
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"test.task/backend/proxy/internal/metrics"
+	"test.task/backend/proxy/internal/model"
+)
+
+func TestRules(t *testing.T) {
+	errHalted := errors.New("halted")
+	open := func(instrument string, volume float64) model.OrderRequest {
+		return model.OrderRequest{
+			ClientID:   1,
+			ReqType:    model.RequestTypeOpen,
+			OrderKind:  model.OrderKindBuy,
+			Volume:     volume,
+			Instrument: instrument,
+		}
+	}
+	closeOrder := open("USDRUB", 50)
+	closeOrder.ReqType = model.RequestTypeClose
+
+	cases := []struct {
+		name     string
+		rules    []Rule
+		input    []model.OrderRequest
+		wantErrs []error
+	}{
+		{
+			name:     "fat finger",
+			rules:    []Rule{MaxOrderVolume(500)},
+			input:    []model.OrderRequest{open("USDRUB", 500), open("USDRUB", 501)},
+			wantErrs: []error{nil, model.ErrOrderVolumeExceedes},
+		},
+		{
+			name:     "blacklisted instrument",
+			rules:    []Rule{NewBlacklist("XLMEUR", "BTCUSD")},
+			input:    []model.OrderRequest{open("USDRUB", 100), open("XLMEUR", 100)},
+			wantErrs: []error{nil, model.ErrInstrumentBlacklisted},
+		},
+		{
+			name: "custom rule sees the state",
+			rules: []Rule{RuleFunc(func(order model.OrderRequest, state State) error {
+				if state.Account.VolumeSum > 0 {
+					return errHalted
+				}
+				return nil
+			})},
+			input:    []model.OrderRequest{open("USDRUB", 100), open("XLMEUR", 100)},
+			wantErrs: []error{nil, errHalted},
+		},
+		{
+			name:     "close order passes limits",
+			rules:    []Rule{ClientOrdersLimit(1), MaxOrderVolume(50)},
+			input:    []model.OrderRequest{open("USDRUB", 50), open("USDRUB", 50), closeOrder},
+			wantErrs: []error{nil, model.ErrClientOrdersExceedes, nil},
+		},
+		{
+			name:     "first rejection wins",
+			rules:    []Rule{MaxOrderVolume(50), NewBlacklist("USDRUB")},
+			input:    []model.OrderRequest{open("USDRUB", 100)},
+			wantErrs: []error{model.ErrOrderVolumeExceedes},
+		},
+	}
+	for _, tc := range cases {
+		svc := NewOrdersService(10, 4000, WithRules(tc.rules...))
+		for i, order := range tc.input {
+			if err := svc.ProcessOrder(order); !errors.Is(err, tc.wantErrs[i]) {
+				t.Fatalf("%s failed: expected err %d: %v, got: %v", tc.name, i, tc.wantErrs[i], err)
+			}
+		}
+	}
+}
+
+func TestShadowRules(t *testing.T) {
+	open := model.OrderRequest{
+		ClientID:   7,
+		ReqType:    model.RequestTypeOpen,
+		OrderKind:  model.OrderKindBuy,
+		Volume:     100,
+		Instrument: "USDRUB",
+	}
+	windows := NewVolumeWindows(Window{Period: time.Hour, Limit: 250})
+	svc := NewOrdersService(4, 4000, WithShadowRules(OrdersLimit(2), windows))
+	beforeN := counter(metrics.ShadowRejections, model.ErrNumberExceedes.Error())
+
+	for i := 0; i < 4; i++ {
+		if err := svc.ProcessOrder(open); err != nil {
+			t.Fatalf("order %d: shadow rules must not reject, got: %v", i, err)
+		}
+	}
+	if err := svc.ProcessOrder(open); !errors.Is(err, model.ErrNumberExceedes) {
+		t.Fatalf("expected live rejection: %v, got: %v", model.ErrNumberExceedes, err)
+	}
+	// the third order is over the shadow N, the fourth one too
+	if got := counter(metrics.ShadowRejections, model.ErrNumberExceedes.Error()) - beforeN; got != 2 {
+		t.Fatalf("expected 2 shadow rejections of N, got: %d", got)
+	}
+	if got := len(windows.opened[7]["USDRUB"]); got != 4 {
+		t.Fatalf("expected shadow windows to see 4 accepted orders, got: %d", got)
+	}
+}
+
+func counter(m *expvar.Map, key string) int64 {
+	v, ok := m.Get(key).(*expvar.Int)
+	if !ok {
+		return 0
+	}
+	return v.Value()
+}
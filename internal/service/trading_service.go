@@ -0,0 +1,220 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"log"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+// AnyInstrument is the schedule key applied to instruments without their own schedule
+const AnyInstrument = "*"
+
+// Session is a trading session within a day in the timezone of its schedule.
+// A session closing before it opens ends on the next day.
+type Session struct {
+	// Weekdays the session opens on like "Mon", every day if empty
+	Weekdays []string `json:"weekdays"`
+	// Open and Close are times of day like "10:00"
+	Open  string `json:"open"`
+	Close string `json:"close"`
+}
+
+// Schedule is the trading sessions of an instrument
+type Schedule struct {
+	// Timezone is an IANA name like "Europe/Moscow", UTC if empty
+	Timezone string    `json:"timezone"`
+	Sessions []Session `json:"sessions"`
+}
+
+// LoadSchedules reads schedules per instrument from a JSON file
+func LoadSchedules(path string) (map[string]Schedule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var res map[string]Schedule
+	if err := json.NewDecoder(f).Decode(&res); err != nil {
+		return nil, fmt.Errorf("decode schedules: %w", err)
+	}
+	return res, nil
+}
+
+type session struct {
+	weekdays map[time.Weekday]bool
+	// open and close are minutes since midnight
+	open, close int
+}
+
+type schedule struct {
+	loc      *time.Location
+	sessions []session
+}
+
+var weekdays = map[string]time.Weekday{
+	"Sun": time.Sunday,
+	"Mon": time.Monday,
+	"Tue": time.Tuesday,
+	"Wed": time.Wednesday,
+	"Thu": time.Thursday,
+	"Fri": time.Friday,
+	"Sat": time.Saturday,
+}
+
+func parseSchedule(sc Schedule) (schedule, error) {
+	res := schedule{loc: time.UTC}
+	if sc.Timezone != "" {
+		loc, err := time.LoadLocation(sc.Timezone)
+		if err != nil {
+			return schedule{}, err
+		}
+		res.loc = loc
+	}
+	for _, s := range sc.Sessions {
+		openAt, err := parseTimeOfDay(s.Open)
+		if err != nil {
+			return schedule{}, err
+		}
+		closeAt, err := parseTimeOfDay(s.Close)
+		if err != nil {
+			return schedule{}, err
+		}
+		parsed := session{open: openAt, close: closeAt}
+		if len(s.Weekdays) > 0 {
+			parsed.weekdays = make(map[time.Weekday]bool, len(s.Weekdays))
+		}
+		for _, day := range s.Weekdays {
+			wd, ok := weekdays[day]
+			if !ok {
+				return schedule{}, fmt.Errorf("invalid weekday %q", day)
+			}
+			parsed.weekdays[wd] = true
+		}
+		res.sessions = append(res.sessions, parsed)
+	}
+	return res, nil
+}
+
+func parseTimeOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (s session) opensOn(day time.Weekday) bool {
+	return s.weekdays == nil || s.weekdays[day]
+}
+
+// isOpen tells whether the schedule has a session open at the moment
+func (sc schedule) isOpen(now time.Time) bool {
+	now = now.In(sc.loc)
+	minute := now.Hour()*60 + now.Minute()
+	today, yesterday := now.Weekday(), now.AddDate(0, 0, -1).Weekday()
+	for _, s := range sc.sessions {
+		if s.open < s.close {
+			if s.opensOn(today) && minute >= s.open && minute < s.close {
+				return true
+			}
+			continue
+		}
+		if s.opensOn(today) && minute >= s.open || s.opensOn(yesterday) && minute < s.close {
+			return true
+		}
+	}
+	return false
+}
+
+// tradingService tells whether an instrument can be traded at the moment,
+// it's consulted before the orders service
+type tradingService struct {
+	sync.RWMutex
+	schedules map[string]schedule
+	halted    map[string]struct{}
+	// closeWhenHalted allows close orders on halted instruments
+	// and outside of trading sessions
+	closeWhenHalted bool
+	now             func() time.Time
+}
+
+// NewTradingService returns the service trading instruments by the schedules,
+// instruments without schedule are traded around the clock
+func NewTradingService(schedules map[string]Schedule, closeWhenHalted bool) (*tradingService, error) {
+	svc := &tradingService{
+		schedules:       make(map[string]schedule, len(schedules)),
+		halted:          make(map[string]struct{}),
+		closeWhenHalted: closeWhenHalted,
+		now:             time.Now,
+	}
+	for instrument, sc := range schedules {
+		parsed, err := parseSchedule(sc)
+		if err != nil {
+			return nil, fmt.Errorf("schedule of %s: %w", instrument, err)
+		}
+		svc.schedules[instrument] = parsed
+	}
+	log.Printf("trading service started. schedules: %d, close orders when halted: %t\n", len(schedules), closeWhenHalted)
+
+	return svc, nil
+}
+
+// CheckOrder returns an error if the order's instrument is halted
+// or out of its trading sessions
+func (svc *tradingService) CheckOrder(order model.OrderRequest) error {
+	if order.ReqType == model.RequestTypeClose && svc.closeWhenHalted {
+		return nil
+	}
+
+	svc.RLock()
+	_, halted := svc.halted[order.Instrument]
+	svc.RUnlock()
+	if halted {
+		return model.ErrInstrumentHalted
+	}
+
+	sc, ok := svc.schedules[order.Instrument]
+	if !ok {
+		sc, ok = svc.schedules[AnyInstrument]
+	}
+	if ok && !sc.isOpen(svc.now()) {
+		return model.ErrOutsideTradingHours
+	}
+	return nil
+}
+
+// Halt stops trading on the instrument until it's resumed
+func (svc *tradingService) Halt(instrument string) {
+	svc.Lock()
+	defer svc.Unlock()
+	svc.halted[instrument] = struct{}{}
+	log.Printf("instrument %s halted", instrument)
+}
+
+// Resume resumes trading on the halted instrument
+func (svc *tradingService) Resume(instrument string) {
+	svc.Lock()
+	defer svc.Unlock()
+	delete(svc.halted, instrument)
+	log.Printf("instrument %s resumed", instrument)
+}
+
+// Halted returns the halted instruments
+func (svc *tradingService) Halted() []string {
+	svc.RLock()
+	defer svc.RUnlock()
+	res := make([]string, 0, len(svc.halted))
+	for instrument := range svc.halted {
+		res = append(res, instrument)
+	}
+	sort.Strings(res)
+	return res
+}
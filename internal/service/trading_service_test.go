@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"test.task/backend/proxy/internal/model"
+)
+
+func TestTradingService(t *testing.T) {
+	schedules := map[string]Schedule{
+		"USDRUB": {
+			Timezone: "Europe/Moscow",
+			Sessions: []Session{{Weekdays: []string{"Mon", "Tue", "Wed", "Thu", "Fri"}, Open: "10:00", Close: "18:45"}},
+		},
+		AnyInstrument: {
+			Sessions: []Session{{Open: "22:00", Close: "21:00"}},
+		},
+	}
+	order := func(reqType model.RequestType, instrument string) model.OrderRequest {
+		return model.OrderRequest{ReqType: reqType, OrderKind: model.OrderKindBuy, Volume: 100, Instrument: instrument}
+	}
+	// Monday, 13:00 in Moscow
+	monday := time.Date(2020, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name            string
+		now             time.Time
+		halted          []string
+		closeWhenHalted bool
+		order           model.OrderRequest
+		wantErr         error
+	}{
+		{
+			name:  "in session",
+			now:   monday,
+			order: order(model.RequestTypeOpen, "USDRUB"),
+		},
+		{
+			name:    "after session in timezone of the schedule",
+			now:     monday.Add(6 * time.Hour),
+			order:   order(model.RequestTypeOpen, "USDRUB"),
+			wantErr: model.ErrOutsideTradingHours,
+		},
+		{
+			name:    "weekend",
+			now:     monday.AddDate(0, 0, -1),
+			order:   order(model.RequestTypeOpen, "USDRUB"),
+			wantErr: model.ErrOutsideTradingHours,
+		},
+		{
+			name:  "default schedule over midnight",
+			now:   monday.Add(14 * time.Hour),
+			order: order(model.RequestTypeOpen, "XLMEUR"),
+		},
+		{
+			name:    "default schedule break",
+			now:     monday.Add(11*time.Hour + 30*time.Minute),
+			order:   order(model.RequestTypeOpen, "XLMEUR"),
+			wantErr: model.ErrOutsideTradingHours,
+		},
+		{
+			name:    "halted",
+			now:     monday,
+			halted:  []string{"USDRUB"},
+			order:   order(model.RequestTypeOpen, "USDRUB"),
+			wantErr: model.ErrInstrumentHalted,
+		},
+		{
+			name:    "close on halted instrument",
+			now:     monday,
+			halted:  []string{"USDRUB"},
+			order:   order(model.RequestTypeClose, "USDRUB"),
+			wantErr: model.ErrInstrumentHalted,
+		},
+		{
+			name:            "close on halted instrument allowed",
+			now:             monday,
+			halted:          []string{"USDRUB"},
+			closeWhenHalted: true,
+			order:           order(model.RequestTypeClose, "USDRUB"),
+		},
+		{
+			name:            "close out of session allowed",
+			now:             monday.AddDate(0, 0, -1),
+			closeWhenHalted: true,
+			order:           order(model.RequestTypeClose, "USDRUB"),
+		},
+	}
+	for _, tc := range cases {
+		svc, err := NewTradingService(schedules, tc.closeWhenHalted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		svc.now = func() time.Time { return tc.now }
+		for _, instrument := range tc.halted {
+			svc.Halt(instrument)
+		}
+		if err := svc.CheckOrder(tc.order); !errors.Is(err, tc.wantErr) {
+			t.Fatalf("%s failed: expected err: %v, got: %v", tc.name, tc.wantErr, err)
+		}
+	}
+
+	svc, err := NewTradingService(nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc.Halt("USDRUB")
+	svc.Resume("USDRUB")
+	if err := svc.CheckOrder(order(model.RequestTypeOpen, "USDRUB")); err != nil {
+		t.Fatalf("expected resumed instrument, got: %v", err)
+	}
+
+	if _, err := NewTradingService(map[string]Schedule{"USDRUB": {Timezone: "Nowhere/City"}}, false); err == nil {
+		t.Fatal("expected invalid timezone error")
+	}
+}
@@ -0,0 +1,263 @@
+package upstream
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"test.task/backend/proxy/internal/metrics"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState int
+
+const (
+	// BreakerClosed passes requests to the order server
+	BreakerClosed BreakerState = iota
+	// BreakerOpen fails requests right away
+	BreakerOpen
+	// BreakerHalfOpen passes a few probe requests to tell
+	// whether the order server has recovered
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig is the configuration of circuit breakers
+type BreakerConfig struct {
+	// Window is the number of the latest requests the failure rate is counted over
+	Window int
+	// MinRequests is the number of requests in the window before the breaker can trip
+	MinRequests int
+	// FailureRate is the share of failed requests tripping the breaker, like 0.5
+	FailureRate float64
+	// OpenFor is the time the breaker stays open before probing the server
+	OpenFor time.Duration
+	// Probes is the number of requests passed while half-open,
+	// the breaker closes once all of them succeed
+	Probes int
+}
+
+// Breaker is a circuit breaker of an order server. It trips open once the
+// rate of failed requests, like errors and timeouts, is too high and fails
+// requests right away for a while. Then it lets a few probe requests through
+// and closes if they succeed or opens again if any of them fails.
+//
+// Allow returns the generation of the breaker, bumped on every change of the
+// state, and outcomes are recorded with the generation the request was let
+// through in. Outcomes of other generations are ignored, so requests passed
+// before the breaker tripped aren't taken for probes.
+type Breaker struct {
+	sync.Mutex
+	name  string
+	cfg   BreakerConfig
+	state BreakerState
+	// changedAt is the time of the last change of the state
+	changedAt time.Time
+	gen       uint64
+	// outcomes is a ring of the latest requests, true for failed ones
+	outcomes []bool
+	next     int
+	count    int
+	failures int
+	// probing is the number of probes let through while half-open
+	// and probed the number of succeeded ones
+	probing int
+	probed  int
+	now     func() time.Time
+}
+
+// NewBreaker returns a closed breaker, the name is used in logs and metrics
+func NewBreaker(name string, cfg BreakerConfig) *Breaker {
+	if cfg.Window < 1 {
+		cfg.Window = 1
+	}
+	if cfg.Probes < 1 {
+		cfg.Probes = 1
+	}
+	b := &Breaker{
+		name:     name,
+		cfg:      cfg,
+		outcomes: make([]bool, cfg.Window),
+		gen:      1,
+		now:      time.Now,
+	}
+	metrics.Set(metrics.BreakerStates, name, int64(BreakerClosed))
+	return b
+}
+
+// Allow tells whether a request can be passed to the server
+// and returns the generation its outcome is recorded with
+func (b *Breaker) Allow() (uint64, bool) {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if b.now().Sub(b.changedAt) < b.cfg.OpenFor {
+			return b.gen, false
+		}
+		b.setState(BreakerHalfOpen)
+	case BreakerHalfOpen:
+		// probes never answered are given up on after another OpenFor
+		if b.probing >= b.cfg.Probes && b.now().Sub(b.changedAt) >= b.cfg.OpenFor {
+			b.setState(BreakerHalfOpen)
+		}
+	default:
+		return b.gen, true
+	}
+	if b.probing >= b.cfg.Probes {
+		return b.gen, false
+	}
+	b.probing++
+	return b.gen, true
+}
+
+// Generation returns the current generation of the breaker, outcomes
+// not tied to a request let through by Allow are recorded with it
+func (b *Breaker) Generation() uint64 {
+	b.Lock()
+	defer b.Unlock()
+	return b.gen
+}
+
+// Success records a request of the generation answered by the server
+func (b *Breaker) Success(gen uint64) {
+	b.Lock()
+	defer b.Unlock()
+	if gen != b.gen {
+		return
+	}
+	switch b.state {
+	case BreakerClosed:
+		b.record(false)
+	case BreakerHalfOpen:
+		if b.probed++; b.probed >= b.cfg.Probes {
+			b.setState(BreakerClosed)
+		}
+	}
+}
+
+// Failure records a request of the generation
+// the server failed or didn't answer in time
+func (b *Breaker) Failure(gen uint64) {
+	b.Lock()
+	defer b.Unlock()
+	if gen != b.gen {
+		return
+	}
+	switch b.state {
+	case BreakerClosed:
+		b.record(true)
+		if b.count >= b.cfg.MinRequests && float64(b.failures) >= b.cfg.FailureRate*float64(b.count) {
+			b.setState(BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		b.setState(BreakerOpen)
+	}
+}
+
+// State returns the current state of the breaker
+func (b *Breaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	return b.state
+}
+
+// Reset closes the breaker and forgets the recorded requests
+func (b *Breaker) Reset() {
+	b.Lock()
+	defer b.Unlock()
+	b.setState(BreakerClosed)
+}
+
+func (b *Breaker) record(failed bool) {
+	if b.count == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.count++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+}
+
+func (b *Breaker) setState(state BreakerState) {
+	if state != b.state {
+		log.Printf("breaker %s is %s", b.name, state)
+	}
+	b.state = state
+	b.changedAt = b.now()
+	b.gen++
+	b.probing, b.probed = 0, 0
+	if state == BreakerClosed {
+		b.next, b.count, b.failures = 0, 0, 0
+	}
+	metrics.Set(metrics.BreakerStates, b.name, int64(state))
+}
+
+// DefaultRouteName is the name of the breaker of the default route
+const DefaultRouteName = "default"
+
+// Breakers holds a breaker per route, created on the first request to it
+type Breakers struct {
+	sync.Mutex
+	cfg      BreakerConfig
+	breakers map[string]*Breaker
+}
+
+func NewBreakers(cfg BreakerConfig) *Breakers {
+	return &Breakers{
+		cfg:      cfg,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get returns the breaker of the route pattern, empty for the default route
+func (bs *Breakers) Get(route string) *Breaker {
+	if route == "" {
+		route = DefaultRouteName
+	}
+	bs.Lock()
+	defer bs.Unlock()
+	b, ok := bs.breakers[route]
+	if !ok {
+		b = NewBreaker(route, bs.cfg)
+		bs.breakers[route] = b
+	}
+	return b
+}
+
+// States returns states of the breakers by route
+func (bs *Breakers) States() map[string]string {
+	bs.Lock()
+	defer bs.Unlock()
+	res := make(map[string]string, len(bs.breakers))
+	for route, b := range bs.breakers {
+		res[route] = b.State().String()
+	}
+	return res
+}
+
+// Reset closes the breaker of the route, it returns false if there's none
+func (bs *Breakers) Reset(route string) bool {
+	bs.Lock()
+	b, ok := bs.breakers[route]
+	bs.Unlock()
+	if ok {
+		b.Reset()
+	}
+	return ok
+}
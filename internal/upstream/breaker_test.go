@@ -0,0 +1,93 @@
+package upstream
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := NewBreaker("test", BreakerConfig{Window: 4, MinRequests: 4, FailureRate: 0.5, OpenFor: time.Second, Probes: 2})
+	b.now = func() time.Time { return now }
+
+	// a single failure out of four doesn't trip the breaker
+	gen := b.Generation()
+	b.Failure(gen)
+	b.Success(gen)
+	b.Success(gen)
+	b.Success(gen)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("Expected closed, got %s", got)
+	}
+	// the first failure leaves the window, so it's still one out of four
+	b.Failure(gen)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("Expected closed, got %s", got)
+	}
+	b.Failure(gen)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("Expected open, got %s", got)
+	}
+	if _, ok := b.Allow(); ok {
+		t.Fatal("Expected open breaker to fail requests")
+	}
+
+	// two probes are let through once OpenFor passes, a failed one opens it again
+	now = now.Add(time.Second)
+	probe, ok1 := b.Allow()
+	_, ok2 := b.Allow()
+	_, ok3 := b.Allow()
+	if !ok1 || !ok2 || ok3 {
+		t.Fatal("Expected half-open breaker to let two probes through")
+	}
+	// requests passed before the breaker tripped aren't probes
+	b.Success(gen)
+	b.Success(gen)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("Expected half-open after stale successes, got %s", got)
+	}
+	b.Failure(gen)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("Expected half-open after stale failure, got %s", got)
+	}
+	b.Failure(probe)
+	if got := b.State(); got != BreakerOpen {
+		t.Fatalf("Expected open after failed probe, got %s", got)
+	}
+
+	now = now.Add(time.Second)
+	late := probe
+	probe, _ = b.Allow()
+	b.Allow()
+	// the other probe of the last round fails late
+	b.Failure(late)
+	b.Success(probe)
+	if got := b.State(); got != BreakerHalfOpen {
+		t.Fatalf("Expected half-open until all probes succeed, got %s", got)
+	}
+	b.Success(probe)
+	if got := b.State(); got != BreakerClosed {
+		t.Fatalf("Expected closed after succeeded probes, got %s", got)
+	}
+	if _, ok := b.Allow(); !ok {
+		t.Fatal("Expected closed breaker to pass requests")
+	}
+}
+
+func TestBreakers(t *testing.T) {
+	bs := NewBreakers(BreakerConfig{Window: 1, MinRequests: 1, FailureRate: 1, OpenFor: time.Minute})
+	bs.Get("").Failure(1)
+	bs.Get("USD*").Success(1)
+
+	want := map[string]string{DefaultRouteName: "open", "USD*": "closed"}
+	got := bs.States()
+	if len(got) != len(want) || got[DefaultRouteName] != want[DefaultRouteName] || got["USD*"] != want["USD*"] {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	if !bs.Reset(DefaultRouteName) || bs.Get("").State() != BreakerClosed {
+		t.Fatal("Expected reset breaker to be closed")
+	}
+	if bs.Reset("XLM*") {
+		t.Fatal("Expected reset of unknown route to fail")
+	}
+}
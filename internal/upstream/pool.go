@@ -0,0 +1,126 @@
+// Package upstream keeps track of the order server backends
+package upstream
+
+import (
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"test.task/backend/proxy/internal/metrics"
+)
+
+type backend struct {
+	addr    string
+	healthy int32
+}
+
+func (b *backend) isHealthy() bool {
+	return atomic.LoadInt32(&b.healthy) == 1
+}
+
+// setHealthy updates health of the backend and tells whether it has changed
+func (b *backend) setHealthy(healthy bool) bool {
+	var v int32
+	if healthy {
+		v = 1
+	}
+	metrics.Set(metrics.BackendsHealthy, b.addr, int64(v))
+	return atomic.SwapInt32(&b.healthy, v) != v
+}
+
+// Pool is a list of order server backends with their health. By default
+// it's active/passive: clients go to the first healthy backend. In sticky
+// mode clients are spread over backends by client ID and stay on the same
+// backend while it's healthy.
+type Pool struct {
+	backends []*backend
+	sticky   bool
+	// probe checks whether the backend is reachable
+	probe func(addr string) error
+}
+
+func NewPool(addrs []string, sticky bool) *Pool {
+	p := &Pool{
+		sticky: sticky,
+		probe:  dialProbe,
+	}
+	for _, addr := range addrs {
+		b := &backend{addr: addr}
+		b.setHealthy(true)
+		p.backends = append(p.backends, b)
+	}
+	return p
+}
+
+// Candidates returns backend addresses to try for the client in order,
+// healthy ones first. Unhealthy backends are still tried as the last resort
+// as they may have recovered since the last check.
+func (p *Pool) Candidates(clientID uint32) []string {
+	n := len(p.backends)
+	start := 0
+	if p.sticky && n > 0 {
+		start = int(clientID % uint32(n))
+	}
+	healthy := make([]string, 0, n)
+	var unhealthy []string
+	for i := 0; i < n; i++ {
+		b := p.backends[(start+i)%n]
+		if b.isHealthy() {
+			healthy = append(healthy, b.addr)
+			continue
+		}
+		unhealthy = append(unhealthy, b.addr)
+	}
+	return append(healthy, unhealthy...)
+}
+
+// MarkDown marks the backend unhealthy until the next successful check
+func (p *Pool) MarkDown(addr string) {
+	for _, b := range p.backends {
+		if b.addr == addr && b.setHealthy(false) {
+			log.Printf("backend %s is down", addr)
+		}
+	}
+}
+
+// MarkUp marks the backend healthy
+func (p *Pool) MarkUp(addr string) {
+	for _, b := range p.backends {
+		if b.addr == addr && b.setHealthy(true) {
+			log.Printf("backend %s is up", addr)
+		}
+	}
+}
+
+// Run checks health of the backends every interval until stop is closed
+func (p *Pool) Run(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			p.check()
+		}
+	}
+}
+
+func (p *Pool) check() {
+	for _, b := range p.backends {
+		if err := p.probe(b.addr); err != nil {
+			p.MarkDown(b.addr)
+			continue
+		}
+		p.MarkUp(b.addr)
+	}
+}
+
+func dialProbe(addr string) error {
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
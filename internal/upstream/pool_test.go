@@ -0,0 +1,73 @@
+package upstream
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCandidates(t *testing.T) {
+	addrs := []string{"a:1", "b:1", "c:1"}
+	cases := []struct {
+		name     string
+		sticky   bool
+		down     []string
+		clientID uint32
+		want     []string
+	}{
+		{
+			name:     "failover all healthy",
+			clientID: 7,
+			want:     []string{"a:1", "b:1", "c:1"},
+		},
+		{
+			name:     "failover primary down",
+			down:     []string{"a:1"},
+			clientID: 7,
+			want:     []string{"b:1", "c:1", "a:1"},
+		},
+		{
+			name:     "sticky by client",
+			sticky:   true,
+			clientID: 7,
+			want:     []string{"b:1", "c:1", "a:1"},
+		},
+		{
+			name:     "sticky backend down",
+			sticky:   true,
+			down:     []string{"b:1"},
+			clientID: 7,
+			want:     []string{"c:1", "a:1", "b:1"},
+		},
+	}
+	for _, tc := range cases {
+		p := NewPool(addrs, tc.sticky)
+		for _, addr := range tc.down {
+			p.MarkDown(addr)
+		}
+		if got := p.Candidates(tc.clientID); !reflect.DeepEqual(got, tc.want) {
+			t.Fatalf("%s failed: expected %v, got %v", tc.name, tc.want, got)
+		}
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	p := NewPool([]string{"a:1", "b:1"}, false)
+	down := map[string]bool{"a:1": true}
+	p.probe = func(addr string) error {
+		if down[addr] {
+			return errors.New("refused")
+		}
+		return nil
+	}
+
+	p.check()
+	if got := p.Candidates(1); got[0] != "b:1" {
+		t.Fatalf("expected b:1 first, got %v", got)
+	}
+	down["a:1"] = false
+	p.check()
+	if got := p.Candidates(1); got[0] != "a:1" {
+		t.Fatalf("expected recovered a:1 first, got %v", got)
+	}
+}
@@ -0,0 +1,46 @@
+package upstream
+
+import "strings"
+
+// Backends picks order servers for clients
+type Backends interface {
+	// Candidates returns backend addresses to try for the client in order
+	Candidates(clientID uint32) []string
+	MarkDown(addr string)
+}
+
+// Route sends orders on instruments matching the pattern to the backends.
+// The pattern is an instrument name like "USDRUB" or a prefix like "USD*".
+type Route struct {
+	Pattern  string
+	Backends Backends
+}
+
+func (r Route) matches(instrument string) bool {
+	if strings.HasSuffix(r.Pattern, "*") {
+		return strings.HasPrefix(instrument, strings.TrimSuffix(r.Pattern, "*"))
+	}
+	return instrument == r.Pattern
+}
+
+// Router picks backends by instrument, the first matching route wins
+// and instruments matching no route go to the default backends
+type Router struct {
+	routes []Route
+	def    Backends
+}
+
+func NewRouter(def Backends, routes ...Route) *Router {
+	return &Router{routes: routes, def: def}
+}
+
+// Route returns the pattern of the route the instrument matches, empty for
+// the default route, and backends of the route
+func (r *Router) Route(instrument string) (string, Backends) {
+	for _, route := range r.routes {
+		if route.matches(instrument) {
+			return route.Pattern, route.Backends
+		}
+	}
+	return "", r.def
+}
@@ -0,0 +1,34 @@
+package upstream
+
+import "testing"
+
+func TestRouter(t *testing.T) {
+	def := NewPool([]string{"default:1"}, false)
+	usd := NewPool([]string{"usd:1"}, false)
+	xlm := NewPool([]string{"xlm:1"}, false)
+	r := NewRouter(def,
+		Route{Pattern: "USDRUB", Backends: xlm},
+		Route{Pattern: "USD*", Backends: usd},
+		Route{Pattern: "XLM*", Backends: xlm},
+	)
+
+	cases := []struct {
+		instrument  string
+		wantPattern string
+		wantAddr    string
+	}{
+		{"USDRUB", "USDRUB", "xlm:1"},
+		{"USDEUR", "USD*", "usd:1"},
+		{"XLMEUR", "XLM*", "xlm:1"},
+		{"EURUSD", "", "default:1"},
+	}
+	for _, tc := range cases {
+		pattern, backends := r.Route(tc.instrument)
+		if pattern != tc.wantPattern {
+			t.Fatalf("%s: expected route %q, got %q", tc.instrument, tc.wantPattern, pattern)
+		}
+		if addr := backends.Candidates(1)[0]; addr != tc.wantAddr {
+			t.Fatalf("%s: expected backend %s, got %s", tc.instrument, tc.wantAddr, addr)
+		}
+	}
+}
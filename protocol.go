@@ -2,36 +2,54 @@ package protocol
 
 import (
 	"encoding/binary"
+	"errors"
 	"math"
 )
 
 type (
 	// OrderRequest ...
 	OrderRequest struct {
-		ClientID   uint32  // 4
-		ID         uint32  // 4
-		ReqType    uint8   // 1
-		OrderKind  uint8   // 1
-		Volume     float64 // 8
-		Instrument string  // variadic
+		ClientID   uint32  `json:"clientId"`   // 4
+		ID         uint32  `json:"id"`         // 4
+		ReqType    uint8   `json:"reqType"`    // 1
+		OrderKind  uint8   `json:"orderKind"`  // 1
+		Volume     float64 `json:"volume"`     // 8
+		Instrument string  `json:"instrument"` // variadic
 	}
 
 	// OrderResponse ...
 	OrderResponse struct {
-		ID   uint32 // 4
-		Code uint16 // 2
+		ID   uint32 `json:"id"`   // 4
+		Code uint16 `json:"code"` // 2
 	}
 )
 
+const (
+	// OrderRequestFixedLen is the length of an encoded request without instrument
+	OrderRequestFixedLen = 18
+	// OrderResponseLen is the length of an encoded response
+	OrderResponseLen = 6
+)
+
 var (
+	// ErrMessageTooShort is returned when a binary message is shorter
+	// than its fixed part
+	ErrMessageTooShort = errors.New("message too short")
+
 	bo        = binary.LittleEndian
-	reqFixLen = 18
-	resFixLen = 6
+	reqFixLen = OrderRequestFixedLen
+	resFixLen = OrderResponseLen
 )
 
 // EncodeOrderRequest ...
 func EncodeOrderRequest(req OrderRequest) []byte {
-	res := make([]byte, reqFixLen+len(req.Instrument))
+	return AppendOrderRequest(make([]byte, 0, reqFixLen+len(req.Instrument)), req)
+}
+
+// AppendOrderRequest appends encoded request to dst and returns the extended
+// buffer, it doesn't allocate if dst has enough capacity
+func AppendOrderRequest(dst []byte, req OrderRequest) []byte {
+	dst, res := grow(dst, reqFixLen+len(req.Instrument))
 	c := 0
 
 	bo.PutUint32(res[c:c+4], req.ClientID)
@@ -49,14 +67,28 @@ func EncodeOrderRequest(req OrderRequest) []byte {
 	PutFloat64(res[c:c+8], req.Volume)
 	c += 8
 
-	copy(res[c:], []byte(req.Instrument))
-	return res
+	copy(res[c:], req.Instrument)
+	return dst
 }
 
 // DecodeOrderRequest decodes request
 func DecodeOrderRequest(body []byte) OrderRequest {
-
 	res := OrderRequest{}
+	decodeOrderRequest(body, &res)
+	return res
+}
+
+// DecodeOrderRequestInto decodes request into req without allocations,
+// instruments are interned
+func DecodeOrderRequestInto(body []byte, req *OrderRequest) error {
+	if len(body) < reqFixLen {
+		return ErrMessageTooShort
+	}
+	decodeOrderRequest(body, req)
+	return nil
+}
+
+func decodeOrderRequest(body []byte, res *OrderRequest) {
 	c := 0
 
 	res.ClientID = bo.Uint32(body[c : c+4])
@@ -74,33 +106,62 @@ func DecodeOrderRequest(body []byte) OrderRequest {
 	res.Volume = Float64FromBytes(body[c : c+8])
 	c += 8
 
-	res.Instrument = string(body[c:])
-	return res
+	res.Instrument = instruments.intern(body[c:])
 }
 
 // DecodeOrderResponse decodes request
 func DecodeOrderResponse(body []byte) OrderResponse {
 	res := OrderResponse{}
+	decodeOrderResponse(body, &res)
+	return res
+}
+
+// DecodeOrderResponseInto decodes response into res
+func DecodeOrderResponseInto(body []byte, res *OrderResponse) error {
+	if len(body) < resFixLen {
+		return ErrMessageTooShort
+	}
+	decodeOrderResponse(body, res)
+	return nil
+}
+
+func decodeOrderResponse(body []byte, res *OrderResponse) {
 	c := 0
 
 	res.ID = bo.Uint32(body[c : c+4])
 	c += 4
 
 	res.Code = bo.Uint16(body[c:])
-	return res
 }
 
 func EncodeOrderResponse(resp OrderResponse) []byte {
-	res := make([]byte, resFixLen, resFixLen)
+	return AppendOrderResponse(make([]byte, 0, resFixLen), resp)
+}
+
+// AppendOrderResponse appends encoded response to dst and returns the extended
+// buffer, it doesn't allocate if dst has enough capacity
+func AppendOrderResponse(dst []byte, resp OrderResponse) []byte {
+	dst, res := grow(dst, resFixLen)
 	c := 0
 
 	bo.PutUint32(res[c:c+4], resp.ID)
 	c += 4
 
 	bo.PutUint16(res[c:c+2], resp.Code)
-	c += 2
 
-	return res
+	return dst
+}
+
+// grow extends dst by n bytes and returns it along with the extension
+func grow(dst []byte, n int) ([]byte, []byte) {
+	l := len(dst)
+	if cap(dst)-l < n {
+		extended := make([]byte, l, 2*cap(dst)+n)
+		copy(extended, dst)
+		dst = extended
+	}
+	dst = dst[:l+n]
+	return dst, dst[l:]
 }
 
 func Float64FromBytes(bytes []byte) float64 {
@@ -0,0 +1,107 @@
+package protocol
+
+import (
+	"errors"
+	"math"
+)
+
+// SubprotocolBatch is the WebSocket subprotocol of batched binary frames:
+//
+//	request frame:  count (uint16) | [len (uint16) | request (len bytes)] * count
+//	response frame: count (uint16) | response (6 bytes) * count
+const SubprotocolBatch = "orders.batch"
+
+// MaxBatchSize is the maximum number of messages in a batch frame
+const MaxBatchSize = math.MaxUint16
+
+var (
+	// ErrMalformedBatch is returned when a batch frame doesn't match its header
+	ErrMalformedBatch = errors.New("malformed batch")
+	// ErrBatchTooLarge is returned when there are more than MaxBatchSize messages
+	ErrBatchTooLarge = errors.New("batch too large")
+)
+
+const batchHeaderLen = 2
+
+// EncodeOrderRequestBatch encodes requests into a single batch frame
+func EncodeOrderRequestBatch(reqs []OrderRequest) ([]byte, error) {
+	if len(reqs) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	size := batchHeaderLen
+	for _, req := range reqs {
+		size += 2 + reqFixLen + len(req.Instrument)
+	}
+
+	res := make([]byte, batchHeaderLen, size)
+	bo.PutUint16(res[:2], uint16(len(reqs)))
+	for _, req := range reqs {
+		var l []byte
+		res, l = grow(res, 2)
+		bo.PutUint16(l, uint16(reqFixLen+len(req.Instrument)))
+		res = AppendOrderRequest(res, req)
+	}
+	return res, nil
+}
+
+// DecodeOrderRequestBatch decodes requests of a batch frame
+func DecodeOrderRequestBatch(body []byte) ([]OrderRequest, error) {
+	if len(body) < batchHeaderLen {
+		return nil, ErrMalformedBatch
+	}
+	count := int(bo.Uint16(body[:2]))
+	c := batchHeaderLen
+	// the header isn't trusted with the allocation
+	// before the body is known to hold that many requests
+	if count > (len(body)-batchHeaderLen)/(2+reqFixLen) {
+		return nil, ErrMalformedBatch
+	}
+
+	reqs := make([]OrderRequest, 0, count)
+	for i := 0; i < count; i++ {
+		if len(body) < c+2 {
+			return nil, ErrMalformedBatch
+		}
+		l := int(bo.Uint16(body[c : c+2]))
+		c += 2
+		if l < reqFixLen || len(body) < c+l {
+			return nil, ErrMalformedBatch
+		}
+		reqs = append(reqs, DecodeOrderRequest(body[c:c+l]))
+		c += l
+	}
+	if c != len(body) {
+		return nil, ErrMalformedBatch
+	}
+	return reqs, nil
+}
+
+// EncodeOrderResponseBatch encodes responses into a single batch frame
+func EncodeOrderResponseBatch(resps []OrderResponse) ([]byte, error) {
+	if len(resps) > MaxBatchSize {
+		return nil, ErrBatchTooLarge
+	}
+	res := make([]byte, batchHeaderLen, batchHeaderLen+len(resps)*resFixLen)
+	bo.PutUint16(res[:2], uint16(len(resps)))
+	for _, resp := range resps {
+		res = AppendOrderResponse(res, resp)
+	}
+	return res, nil
+}
+
+// DecodeOrderResponseBatch decodes responses of a batch frame
+func DecodeOrderResponseBatch(body []byte) ([]OrderResponse, error) {
+	if len(body) < batchHeaderLen {
+		return nil, ErrMalformedBatch
+	}
+	count := int(bo.Uint16(body[:2]))
+	if len(body) != batchHeaderLen+count*resFixLen {
+		return nil, ErrMalformedBatch
+	}
+
+	resps := make([]OrderResponse, 0, count)
+	for c := batchHeaderLen; c < len(body); c += resFixLen {
+		resps = append(resps, DecodeOrderResponse(body[c:c+resFixLen]))
+	}
+	return resps, nil
+}
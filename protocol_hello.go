@@ -0,0 +1,85 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+)
+
+// Protocol versions. A client announces its version in a hello frame before
+// the first order, clients sending an order right away speak ProtocolV1.
+const (
+	// ProtocolV1 has result codes 0-3 from the original task description
+	ProtocolV1 uint16 = 1
+	// ProtocolV2 adds result codes telling apart the reasons of rejection
+	ProtocolV2 uint16 = 2
+	// CurrentVersion is the latest version known to this package
+	CurrentVersion = ProtocolV2
+)
+
+// Capability is a feature a client wants to use in a session
+type Capability uint32
+
+const (
+	// CapabilityJSON is the JSON encoding, same as SubprotocolJSON
+	CapabilityJSON Capability = 1 << iota
+	// CapabilityBatch is the batched binary encoding, same as SubprotocolBatch
+	CapabilityBatch
+)
+
+// Hello is the handshake frame:
+//
+//	magic "OPXH" (4) | version (uint16) | capabilities (uint32)
+//
+// It's shorter than any order request, so it can't be mistaken for one.
+// The proxy answers with a hello frame holding the selected version and
+// the accepted capabilities.
+type Hello struct {
+	Version      uint16
+	Capabilities Capability
+}
+
+const helloLen = 10
+
+var (
+	helloMagic = []byte("OPXH")
+
+	// ErrNotHello is returned when a frame isn't a hello frame
+	ErrNotHello = errors.New("not a hello frame")
+)
+
+// Has tells whether the capability is set
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+// IsHello tells whether the frame is a hello frame
+func IsHello(body []byte) bool {
+	return len(body) == helloLen && bytes.Equal(body[:4], helloMagic)
+}
+
+// EncodeHello encodes hello frame
+func EncodeHello(h Hello) []byte {
+	res := make([]byte, helloLen)
+	c := copy(res, helloMagic)
+
+	bo.PutUint16(res[c:c+2], h.Version)
+	c += 2
+
+	bo.PutUint32(res[c:c+4], uint32(h.Capabilities))
+	return res
+}
+
+// DecodeHello decodes hello frame
+func DecodeHello(body []byte) (Hello, error) {
+	if !IsHello(body) {
+		return Hello{}, ErrNotHello
+	}
+	res := Hello{}
+	c := len(helloMagic)
+
+	res.Version = bo.Uint16(body[c : c+2])
+	c += 2
+
+	res.Capabilities = Capability(bo.Uint32(body[c : c+4]))
+	return res, nil
+}
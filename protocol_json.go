@@ -0,0 +1,36 @@
+package protocol
+
+import (
+	"encoding/json"
+)
+
+// WebSocket subprotocols a client may request to choose the wire format.
+// Clients not requesting any of them use the binary format.
+const (
+	SubprotocolBinary = "orders.binary"
+	SubprotocolJSON   = "orders.json"
+)
+
+// EncodeOrderRequestJSON encodes request as JSON object
+func EncodeOrderRequestJSON(req OrderRequest) ([]byte, error) {
+	return json.Marshal(req)
+}
+
+// DecodeOrderRequestJSON decodes request from JSON object
+func DecodeOrderRequestJSON(body []byte) (OrderRequest, error) {
+	var req OrderRequest
+	err := json.Unmarshal(body, &req)
+	return req, err
+}
+
+// EncodeOrderResponseJSON encodes response as JSON object
+func EncodeOrderResponseJSON(res OrderResponse) ([]byte, error) {
+	return json.Marshal(res)
+}
+
+// DecodeOrderResponseJSON decodes response from JSON object
+func DecodeOrderResponseJSON(body []byte) (OrderResponse, error) {
+	var res OrderResponse
+	err := json.Unmarshal(body, &res)
+	return res, err
+}
@@ -55,6 +55,352 @@ func TestOrderResponseEncode(t *testing.T) {
 	}
 }
 
+func TestOrderJSONEncode(t *testing.T) {
+	for i := 0; i < 1000; i++ {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			wantReq := OrderRequest{
+				ClientID:   seededRand.Uint32(),
+				ID:         seededRand.Uint32(),
+				ReqType:    uint8(seededRand.Uint32()),
+				OrderKind:  uint8(seededRand.Uint32()),
+				Volume:     seededRand.Float64(),
+				Instrument: RandomString(5, 7),
+			}
+			reqBytes, err := EncodeOrderRequestJSON(wantReq)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotReq, err := DecodeOrderRequestJSON(reqBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotReq != wantReq {
+				t.Fatalf("OrderRequest mismatch: %+v", wantReq)
+			}
+
+			wantRes := OrderResponse{
+				ID:   seededRand.Uint32(),
+				Code: uint16(seededRand.Uint32()),
+			}
+			resBytes, err := EncodeOrderResponseJSON(wantRes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotRes, err := DecodeOrderResponseJSON(resBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotRes != wantRes {
+				t.Fatalf("OrderResponse mismatch: %+v", wantRes)
+			}
+		})
+	}
+}
+
+func TestOrderRequestDecodeJSON(t *testing.T) {
+	body := `{"clientId":4815,"id":162342,"reqType":1,"orderKind":2,"volume":1000.5,"instrument":"USDEUR"}`
+	want := OrderRequest{
+		ClientID:   4815,
+		ID:         162342,
+		ReqType:    1,
+		OrderKind:  2,
+		Volume:     1000.5,
+		Instrument: "USDEUR",
+	}
+
+	got, err := DecodeOrderRequestJSON([]byte(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("expected %+v, got %+v", want, got)
+	}
+	if _, err := DecodeOrderRequestJSON([]byte(`{"clientId":"4815"}`)); err == nil {
+		t.Fatal("expected error on invalid client id")
+	}
+}
+
+func TestOrderBatchEncode(t *testing.T) {
+	for i := 0; i < 100; i++ {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			n := seededRand.Intn(10)
+			wantReqs := make([]OrderRequest, n)
+			wantResps := make([]OrderResponse, n)
+			for j := range wantReqs {
+				wantReqs[j] = OrderRequest{
+					ClientID:   seededRand.Uint32(),
+					ID:         seededRand.Uint32(),
+					ReqType:    uint8(seededRand.Uint32()),
+					OrderKind:  uint8(seededRand.Uint32()),
+					Volume:     seededRand.Float64(),
+					Instrument: RandomString(5, 7),
+				}
+				wantResps[j] = OrderResponse{
+					ID:   seededRand.Uint32(),
+					Code: uint16(seededRand.Uint32()),
+				}
+			}
+
+			reqBytes, err := EncodeOrderRequestBatch(wantReqs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotReqs, err := DecodeOrderRequestBatch(reqBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gotReqs) != n {
+				t.Fatalf("expected %d requests, got %d", n, len(gotReqs))
+			}
+			for j := range wantReqs {
+				if gotReqs[j] != wantReqs[j] {
+					t.Fatalf("OrderRequest mismatch: %+v", wantReqs[j])
+				}
+			}
+
+			resBytes, err := EncodeOrderResponseBatch(wantResps)
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotResps, err := DecodeOrderResponseBatch(resBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(gotResps) != n {
+				t.Fatalf("expected %d responses, got %d", n, len(gotResps))
+			}
+			for j := range wantResps {
+				if gotResps[j] != wantResps[j] {
+					t.Fatalf("OrderResponse mismatch: %+v", wantResps[j])
+				}
+			}
+		})
+	}
+}
+
+func TestOrderBatchDecodeMalformed(t *testing.T) {
+	valid, err := EncodeOrderRequestBatch([]OrderRequest{{ID: 1, Instrument: "EURUSD"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		body []byte
+	}{
+		{name: "empty", body: nil},
+		{name: "count without requests", body: []byte{2, 0}},
+		{name: "truncated request", body: valid[:len(valid)-8]},
+		{name: "trailing bytes", body: append(valid, 1)},
+		{name: "request shorter than fixed part", body: []byte{1, 0, 2, 0, 1, 2}},
+	}
+	for _, tc := range cases {
+		if _, err := DecodeOrderRequestBatch(tc.body); err != ErrMalformedBatch {
+			t.Fatalf("%s: expected %v, got %v", tc.name, ErrMalformedBatch, err)
+		}
+	}
+
+	if _, err := DecodeOrderResponseBatch([]byte{2, 0, 1, 2, 3, 4, 5, 6}); err != ErrMalformedBatch {
+		t.Fatalf("expected %v, got %v", ErrMalformedBatch, err)
+	}
+
+	// a count above what the body holds is rejected before allocating
+	allocs := testing.AllocsPerRun(100, func() {
+		if _, err := DecodeOrderRequestBatch([]byte{0xff, 0xff}); err != ErrMalformedBatch {
+			t.Fatalf("expected %v, got %v", ErrMalformedBatch, err)
+		}
+	})
+	if allocs != 0 {
+		t.Fatalf("expected no allocations, got %v", allocs)
+	}
+}
+
+func TestOrderRequestAppend(t *testing.T) {
+	req := OrderRequest{
+		ClientID:   4815,
+		ID:         162342,
+		ReqType:    1,
+		OrderKind:  2,
+		Volume:     1000.5,
+		Instrument: "USDEUR",
+	}
+	prefix := []byte{42}
+
+	got := AppendOrderRequest(prefix, req)
+	if got[0] != 42 {
+		t.Fatalf("prefix overwritten: %v", got)
+	}
+	var decoded OrderRequest
+	if err := DecodeOrderRequestInto(got[1:], &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded != req {
+		t.Fatalf("OrderRequest mismatch: %+v", decoded)
+	}
+	if err := DecodeOrderRequestInto(got[1:10], &decoded); err != ErrMessageTooShort {
+		t.Fatalf("expected %v, got %v", ErrMessageTooShort, err)
+	}
+}
+
+func TestOrderResponseAppend(t *testing.T) {
+	want := OrderResponse{ID: 162342, Code: 2}
+
+	buf := AppendOrderResponse(nil, want)
+	buf = AppendOrderResponse(buf, OrderResponse{ID: 1})
+	if len(buf) != 2*resFixLen {
+		t.Fatalf("expected %d bytes, got %d", 2*resFixLen, len(buf))
+	}
+	var got OrderResponse
+	if err := DecodeOrderResponseInto(buf[:resFixLen], &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("OrderResponse mismatch: %+v", got)
+	}
+	if err := DecodeOrderResponseInto(buf[:3], &got); err != ErrMessageTooShort {
+		t.Fatalf("expected %v, got %v", ErrMessageTooShort, err)
+	}
+}
+
+func TestInstrumentInterning(t *testing.T) {
+	in := newInterner(1)
+
+	a := in.intern([]byte("EURUSD"))
+	b := in.intern([]byte("EURUSD"))
+	if a != "EURUSD" || b != "EURUSD" {
+		t.Fatalf("unexpected interned strings %q, %q", a, b)
+	}
+	if len(in.strings) != 1 {
+		t.Fatalf("expected 1 interned instrument, got %d", len(in.strings))
+	}
+	// the table is full, the instrument is returned but not stored
+	if c := in.intern([]byte("USDRUB")); c != "USDRUB" || len(in.strings) != 1 {
+		t.Fatalf("expected USDRUB not to be interned, got %q and %d instruments", c, len(in.strings))
+	}
+
+	// seeded instruments are interned even if the table is full
+	in.seed("USDRUB", "EURUSD")
+	if _, ok := in.strings["USDRUB"]; !ok || len(in.strings) != 2 {
+		t.Fatalf("expected USDRUB to be interned, got %v", in.strings)
+	}
+	if c := in.intern([]byte("XLMEUR")); c != "XLMEUR" || len(in.strings) != 2 {
+		t.Fatalf("expected XLMEUR not to be interned, got %q and %d instruments", c, len(in.strings))
+	}
+}
+
+func TestCodecsZeroAllocs(t *testing.T) {
+	// random instruments of the other tests could have filled the table
+	defer func(in *interner) { instruments = in }(instruments)
+	instruments = newInterner(maxInternedInstruments)
+
+	req := OrderRequest{
+		ClientID:   4815,
+		ID:         162342,
+		ReqType:    1,
+		OrderKind:  1,
+		Volume:     1000,
+		Instrument: "USDEUR",
+	}
+	res := OrderResponse{ID: 162342, Code: 0}
+	reqBuf := make([]byte, 0, 64)
+	resBuf := make([]byte, 0, 64)
+	encodedReq := EncodeOrderRequest(req)
+	encodedRes := EncodeOrderResponse(res)
+	var decodedReq OrderRequest
+	var decodedRes OrderResponse
+
+	cases := []struct {
+		name string
+		f    func()
+	}{
+		{name: "AppendOrderRequest", f: func() { reqBuf = AppendOrderRequest(reqBuf[:0], req) }},
+		{name: "AppendOrderResponse", f: func() { resBuf = AppendOrderResponse(resBuf[:0], res) }},
+		{name: "DecodeOrderRequestInto", f: func() { DecodeOrderRequestInto(encodedReq, &decodedReq) }},
+		{name: "DecodeOrderResponseInto", f: func() { DecodeOrderResponseInto(encodedRes, &decodedRes) }},
+	}
+	for _, tc := range cases {
+		if allocs := testing.AllocsPerRun(1000, tc.f); allocs != 0 {
+			t.Fatalf("%s: expected zero allocations, got %v", tc.name, allocs)
+		}
+	}
+}
+
+func BenchmarkAppendOrderRequest(b *testing.B) {
+	req := OrderRequest{ClientID: 4815, ID: 162342, ReqType: 1, OrderKind: 1, Volume: 1000, Instrument: "USDEUR"}
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendOrderRequest(buf[:0], req)
+	}
+}
+
+func BenchmarkEncodeOrderRequest(b *testing.B) {
+	req := OrderRequest{ClientID: 4815, ID: 162342, ReqType: 1, OrderKind: 1, Volume: 1000, Instrument: "USDEUR"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		EncodeOrderRequest(req)
+	}
+}
+
+func BenchmarkDecodeOrderRequestInto(b *testing.B) {
+	body := EncodeOrderRequest(OrderRequest{ClientID: 4815, ID: 162342, ReqType: 1, OrderKind: 1, Volume: 1000, Instrument: "USDEUR"})
+	var req OrderRequest
+	defer func(in *interner) { instruments = in }(instruments)
+	instruments = newInterner(maxInternedInstruments)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DecodeOrderRequestInto(body, &req)
+	}
+}
+
+func BenchmarkAppendOrderResponse(b *testing.B) {
+	res := OrderResponse{ID: 162342, Code: 1}
+	buf := make([]byte, 0, 64)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf = AppendOrderResponse(buf[:0], res)
+	}
+}
+
+func BenchmarkDecodeOrderResponseInto(b *testing.B) {
+	body := EncodeOrderResponse(OrderResponse{ID: 162342, Code: 1})
+	var res OrderResponse
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		DecodeOrderResponseInto(body, &res)
+	}
+}
+
+func TestHelloEncode(t *testing.T) {
+	want := Hello{
+		Version:      ProtocolV2,
+		Capabilities: CapabilityBatch | CapabilityJSON,
+	}
+
+	body := EncodeHello(want)
+	if !IsHello(body) {
+		t.Fatalf("expected hello frame: %v", body)
+	}
+	got, err := DecodeHello(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Fatalf("Hello mismatch: %+v", got)
+	}
+	if !got.Capabilities.Has(CapabilityBatch) {
+		t.Fatalf("expected batch capability: %+v", got)
+	}
+
+	order := EncodeOrderRequest(OrderRequest{ClientID: 0x4858504f})
+	if IsHello(order) {
+		t.Fatal("order mistaken for hello frame")
+	}
+	if _, err := DecodeHello(order); err != ErrNotHello {
+		t.Fatalf("expected %v, got %v", ErrNotHello, err)
+	}
+}
+
 func StringWithCharset(length int, charset string) string {
 	var seededRand *rand.Rand = rand.New(
 		rand.NewSource(time.Now().UnixNano()))